@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxAuditEntries bounds the audit log so it cannot fill the router's flash.
+const maxAuditEntries = 5000
+
+// AuditEntry records a single change made to a voucher by an administrator.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	VoucherID int       `json:"voucher_id"`
+	Action    string    `json:"action"`
+	Field     string    `json:"field,omitempty"`
+	Old       string    `json:"old,omitempty"`
+	New       string    `json:"new,omitempty"`
+	Actor     string    `json:"actor,omitempty"` // admin client address
+}
+
+var auditCache []AuditEntry
+
+func loadAuditLog() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	auditCache = []AuditEntry{}
+	return readJSONFile(dataPath("audit.json"), &auditCache)
+}
+
+// recordAudit appends entries to the audit log and persists it, dropping the
+// oldest entries once the log is full.
+func recordAudit(entries ...AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		auditCache = append(auditCache, e)
+	}
+	if len(auditCache) > maxAuditEntries {
+		auditCache = append([]AuditEntry(nil), auditCache[len(auditCache)-maxAuditEntries:]...)
+	}
+	return writeJSONFile(dataPath("audit.json"), auditCache)
+}
+
+// getAuditLog returns the audit entries for a voucher, newest first. A
+// voucherID of 0 returns the whole log.
+func getAuditLog(voucherID int) []AuditEntry {
+	entries := make([]AuditEntry, 0)
+	for i := len(auditCache) - 1; i >= 0; i-- {
+		if voucherID == 0 || auditCache[i].VoucherID == voucherID {
+			entries = append(entries, auditCache[i])
+		}
+	}
+	return entries
+}
+
+// auditField builds an "update" audit entry for a changed voucher field.
+func auditField(voucherID int, actor, field string, before, after interface{}) AuditEntry {
+	return AuditEntry{
+		VoucherID: voucherID,
+		Action:    "update",
+		Field:     field,
+		Old:       fmt.Sprint(before),
+		New:       fmt.Sprint(after),
+		Actor:     actor,
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxLogoBytes caps uploaded logos; the portal has to load fast on a phone
+// that is not online yet.
+const maxLogoBytes = 512 << 10
+
+// Branding customises the portal themes. Empty fields leave each theme's own
+// text and colours in place.
+type Branding struct {
+	SiteName     string `json:"site_name"`
+	Logo         string `json:"logo"` // URL of the uploaded logo, "" for none
+	PrimaryColor string `json:"primary_color"`
+	AccentColor  string `json:"accent_color"`
+	WelcomeText  string `json:"welcome_text"`
+	SupportText  string `json:"support_text"` // e.g. a support phone number
+	FooterText   string `json:"footer_text"`
+	TermsURL     string `json:"terms_url"`
+}
+
+var brandingCache Branding
+
+var (
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// logoTypes maps the image types accepted for the logo to file extensions.
+// SVG is left out because it can carry script.
+var logoTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func loadBranding() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	brandingCache = Branding{}
+	return readJSONFile(dataPath("branding.json"), &brandingCache)
+}
+
+func saveBranding() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return writeJSONFile(dataPath("branding.json"), brandingCache)
+}
+
+// validateBranding trims the fields in place and returns a message describing
+// the first invalid one. Colours end up in theme CSS, so only #rrggbb is
+// accepted.
+func validateBranding(b *Branding) string {
+	for _, f := range []*string{&b.SiteName, &b.PrimaryColor, &b.AccentColor, &b.WelcomeText,
+		&b.SupportText, &b.FooterText, &b.TermsURL} {
+		*f = strings.TrimSpace(*f)
+	}
+	if len(b.SiteName) > 60 {
+		return "Site name must be at most 60 characters"
+	}
+	if len(b.WelcomeText) > 300 || len(b.SupportText) > 120 || len(b.FooterText) > 120 {
+		return "Welcome text, support and footer text are too long"
+	}
+	if b.PrimaryColor != "" && !colorPattern.MatchString(b.PrimaryColor) {
+		return "Primary color must look like #1a2b3c"
+	}
+	if b.AccentColor != "" && !colorPattern.MatchString(b.AccentColor) {
+		return "Accent color must look like #1a2b3c"
+	}
+	if b.TermsURL != "" {
+		u, err := url.Parse(b.TermsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "Terms URL must be an http(s) URL"
+		}
+	}
+	return ""
+}
+
+// saveLogo stores an uploaded logo in the data directory, replacing the old
+// one, and returns the URL it is served from. The query string changes with
+// every upload so browsers do not keep showing the old logo.
+func saveLogo(data []byte) (string, error) {
+	ext, ok := logoTypes[http.DetectContentType(data)]
+	if !ok {
+		return "", fmt.Errorf("logo must be a PNG, JPEG, GIF or WebP image")
+	}
+	for _, old := range logoTypes {
+		os.Remove(dataPath("logo" + old))
+	}
+	if err := os.WriteFile(dataPath("logo"+ext), data, 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/branding/logo?v=%d", time.Now().Unix()), nil
+}
+
+// logoHandler serves the uploaded logo.
+func logoHandler(w http.ResponseWriter, r *http.Request) {
+	for _, ext := range logoTypes {
+		path := dataPath("logo" + ext)
+		if _, err := os.Stat(path); err == nil {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			http.ServeFile(w, r, path)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
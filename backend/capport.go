@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// captiveStatus is the RFC 8908 Captive Portal API response.
+type captiveStatus struct {
+	Captive          bool   `json:"captive"`
+	UserPortalURL    string `json:"user-portal-url,omitempty"`
+	SecondsRemaining int64  `json:"seconds-remaining,omitempty"`
+	BytesRemaining   *int64 `json:"bytes-remaining,omitempty"`
+	CanExtendSession bool   `json:"can-extend-session,omitempty"`
+}
+
+// capportHandler serves the Captive Portal API (RFC 8908) advertised to
+// clients in DHCP option 114. The answer is for whichever client makes the
+// request: its MAC is found from the source IP, and the voucher session bound
+// to that MAC supplies the remaining time and data.
+func capportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/captive+json")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	status := captiveStatus{Captive: true, UserPortalURL: userPortalURL(r)}
+	mac := macForIP(remoteIP(r))
+	if mac == "" {
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	if isListed(macListTrusted, mac) {
+		json.NewEncoder(w).Encode(captiveStatus{Captive: false})
+		return
+	}
+
+	for _, s := range getActiveSessions() {
+		if s.MAC != mac {
+			continue
+		}
+		status.Captive = false
+		seconds, _ := s.remaining(time.Now())
+		status.SecondsRemaining = int64(seconds)
+		status.CanExtendSession = true // with a top-up voucher on the portal
+		if v, d := findActiveVoucher(mac); v != nil {
+			if limit := v.dataLimitFor(d); limit > 0 {
+				left := int64(limit)*bytesPerMB - recordedBytes(v, d) - liveBytes(mac)
+				if left < 0 {
+					left = 0
+				}
+				status.BytesRemaining = &left
+			}
+		}
+		break
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// userPortalURL returns the portal address for the Captive Portal API, or ""
+// when the request did not arrive over HTTPS. RFC 8908 requires an HTTPS URL,
+// and install.sh only advertises the API behind an HTTPS proxy, so the scheme
+// and host are the ones the client used: the TLS connection's, or those the
+// proxy forwards in X-Forwarded-Proto and X-Forwarded-Host.
+func userPortalURL(r *http.Request) string {
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+	if r.TLS == nil && !strings.EqualFold(proto, "https") {
+		return ""
+	}
+	return "https://" + host + "/"
+}
+
+// liveBytes returns the bytes a client has moved in its current NDS session,
+// which are only recorded once the session ends.
+func liveBytes(mac string) int64 {
+	clients, err := nds.Clients()
+	if err != nil {
+		return 0
+	}
+	for _, c := range clients {
+		if c.MAC == mac {
+			return (c.Downloaded + c.Uploaded) * bytesPerNDSKB
+		}
+	}
+	return 0
+}
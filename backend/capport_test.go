@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserPortalURLIsHTTPSOnly(t *testing.T) {
+	plain := httptest.NewRequest(http.MethodGet, "http://10.0.0.1:7891/captive-portal/api", nil)
+	if u := userPortalURL(plain); u != "" {
+		t.Errorf("plain HTTP: %q, want no portal URL", u)
+	}
+
+	proxied := httptest.NewRequest(http.MethodGet, "http://10.0.0.1:7891/captive-portal/api", nil)
+	proxied.Header.Set("X-Forwarded-Proto", "https")
+	proxied.Header.Set("X-Forwarded-Host", "portal.example.net")
+	if u := userPortalURL(proxied); u != "https://portal.example.net/" {
+		t.Errorf("behind a proxy: %q, want https://portal.example.net/", u)
+	}
+
+	direct := httptest.NewRequest(http.MethodGet, "https://portal.example.net/captive-portal/api", nil)
+	direct.TLS = &tls.ConnectionState{}
+	if u := userPortalURL(direct); u != "https://portal.example.net/" {
+		t.Errorf("over TLS: %q, want https://portal.example.net/", u)
+	}
+}
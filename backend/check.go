@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// checkLimiter keeps /check from being used to guess voucher codes.
+var checkLimiter = newRateLimiter(10, time.Minute)
+
+// voucherCheck describes a voucher without redeeming it.
+type voucherCheck struct {
+	Valid            bool       `json:"valid"`
+	Status           string     `json:"status,omitempty"` // unused, active, used, expired or revoked
+	Code             string     `json:"code,omitempty"`   // why the code is not valid, see locales/
+	Message          string     `json:"message,omitempty"`
+	Plan             string     `json:"plan,omitempty"`
+	DurationMinutes  int        `json:"duration_minutes,omitempty"`
+	DataLimitMB      int        `json:"data_limit_mb,omitempty"`
+	Shared           bool       `json:"shared,omitempty"`
+	RemainingSeconds int64      `json:"remaining_seconds,omitempty"`
+	DataUsed         int64      `json:"data_used,omitempty"`   // bytes
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`  // last moment the code can be used
+	ActivateBy       *time.Time `json:"activate_by,omitempty"` // unused codes: last moment to redeem
+	LifetimeMinutes  int        `json:"lifetime_minutes,omitempty"`
+}
+
+// checkVoucher reports what a code is worth and whether it can still be used.
+// Unlike validateVoucher it never binds a device or starts the clock.
+func checkVoucher(v *Voucher) voucherCheck {
+	c := voucherCheck{
+		Plan:            v.Name,
+		DurationMinutes: v.Duration,
+		DataLimitMB:     v.DataLimit,
+		Shared:          v.IsReusable,
+		LifetimeMinutes: v.Lifetime,
+	}
+	if end := v.validUntil(); !end.IsZero() {
+		c.ExpiresAt = &end
+	}
+	if !v.IsUsed && !v.ActivateBy.IsZero() {
+		activateBy := v.ActivateBy
+		c.ActivateBy = &activateBy
+	}
+
+	invalid := v.validityError(time.Now())
+	switch {
+	case v.Revoked:
+		c.Status, c.Code = "revoked", "voucher_revoked"
+	case v.AppliedTo != 0:
+		c.Status, c.Code = "used", "voucher_topped_up"
+	case invalid != "":
+		c.Status, c.Code = "expired", invalid
+	case !v.IsUsed:
+		c.Status = "unused"
+	case v.IsReusable:
+		// Every device on a shared code has its own clock.
+		c.Status = "active"
+		if v.deviceLimitReached() || v.redemptionLimitReached() {
+			c.Status, c.Code = "used", "voucher_all_devices"
+		}
+	default:
+		expiry := v.sessionExpiry(nil)
+		c.DataUsed = recordedBytes(v, nil)
+		switch {
+		case !expiry.IsZero() && time.Now().After(expiry):
+			c.Status, c.Code = "expired", "voucher_time_up"
+		case dataLimitReached(v, nil, 0):
+			c.Status, c.Code = "expired", "voucher_data_limit"
+		default:
+			c.Status = "active"
+			if !expiry.IsZero() {
+				c.RemainingSeconds = int64(time.Until(expiry).Seconds())
+			}
+		}
+	}
+	c.Valid = c.Status == "unused" || c.Status == "active"
+	return c
+}
+
+// checkHandler lets customers and resellers look up a voucher code
+// (?voucher=) without using it. Requests are rate-limited per IP.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !checkLimiter.allow(remoteIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		writePortalError(w, r, http.StatusTooManyRequests, newPortalError("rate_limited"))
+		return
+	}
+
+	code := r.URL.Query().Get("voucher")
+	if code == "" {
+		writePortalError(w, r, http.StatusBadRequest, newPortalError("voucher_required"))
+		return
+	}
+	c := voucherCheck{Code: "voucher_invalid"}
+	if v, err := getVoucherByCode(code); err == nil {
+		c = checkVoucher(v)
+	}
+	if c.Code != "" {
+		c.Message = translate(requestLanguage(r), c.Code)
+	}
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dhcpLeasesPath is where dnsmasq keeps its leases on OpenWrt.
+var dhcpLeasesPath = "/tmp/dhcp.leases"
+
+// arpTablePath is the kernel's IPv4 neighbour table.
+var arpTablePath = "/proc/net/arp"
+
+// connectedClient is a NoDogSplash client merged with the voucher it uses.
+type connectedClient struct {
+	MAC              string `json:"mac"`
+	IP               string `json:"ip"`
+	Hostname         string `json:"hostname,omitempty"`
+	State            string `json:"state"`
+	IdleSeconds      int64  `json:"idle_seconds"`
+	BytesDown        int64  `json:"bytes_down"` // current NDS session
+	BytesUp          int64  `json:"bytes_up"`
+	DataUsed         int64  `json:"data_used"` // all sessions on the voucher, in bytes
+	Token            string `json:"token,omitempty"`
+	VoucherID        int    `json:"voucher_id,omitempty"`
+	VoucherCode      string `json:"voucher_code,omitempty"`
+	VoucherName      string `json:"voucher_name,omitempty"`
+	RemainingSeconds int    `json:"remaining_seconds,omitempty"`
+}
+
+// dhcpHostnames maps MAC addresses to the hostnames clients announced over
+// DHCP. A missing or unreadable leases file just yields no hostnames.
+func dhcpHostnames() map[string]string {
+	hostnames := make(map[string]string)
+	f, err := os.Open(dhcpLeasesPath)
+	if err != nil {
+		return hostnames
+	}
+	defer f.Close()
+
+	// Each line is "<expiry> <mac> <ip> <hostname> <client-id>", with "*" for
+	// an unknown hostname.
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[3] != "*" {
+			hostnames[normalizeMAC(fields[1])] = fields[3]
+		}
+	}
+	return hostnames
+}
+
+// getConnectedClients lists the clients NoDogSplash knows about together with
+// their voucher, remaining time and data use.
+func getConnectedClients() ([]connectedClient, error) {
+	ndsList, err := nds.Clients()
+	if err != nil {
+		return nil, err
+	}
+	hostnames := dhcpHostnames()
+	now := time.Now()
+
+	clients := make([]connectedClient, 0, len(ndsList))
+	for _, c := range ndsList {
+		// NDS reports counters in kB.
+		cc := connectedClient{
+			MAC:       c.MAC,
+			IP:        c.IP,
+			Hostname:  hostnames[c.MAC],
+			State:     c.State,
+			BytesDown: c.Downloaded * bytesPerNDSKB,
+			BytesUp:   c.Uploaded * bytesPerNDSKB,
+			Token:     c.Token,
+		}
+		if c.Active > 0 {
+			cc.IdleSeconds = now.Unix() - c.Active
+		}
+		if v, d := findActiveVoucher(c.MAC); v != nil {
+			cc.VoucherID = v.ID
+			cc.VoucherCode = v.Code
+			cc.VoucherName = v.Name
+			if expiry := v.sessionExpiry(d); !expiry.IsZero() {
+				cc.RemainingSeconds = int(expiry.Sub(now).Seconds())
+			}
+			cc.DataUsed = recordedBytes(v, d)
+			if c.State == ndsStateAuthenticated {
+				cc.DataUsed += cc.BytesDown + cc.BytesUp
+			}
+		}
+		clients = append(clients, cc)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].IP < clients[j].IP })
+	return clients, nil
+}
+
+// macForIP finds the MAC address of the LAN client using ip. NoDogSplash is
+// asked first; clients it has not seen yet (or trusted ones it does not list)
+// are looked up in the ARP table, then the DHCP leases. It returns "" if the
+// IP is unknown.
+func macForIP(ip string) string {
+	if c, err := ndsClientByIP(ip); err == nil && c != nil {
+		return c.MAC
+	}
+
+	// /proc/net/arp: "IP address  HW type  Flags  HW address  Mask  Device"
+	if f, err := os.Open(arpTablePath); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 4 && fields[0] == ip && fields[3] != "00:00:00:00:00:00" {
+				return normalizeMAC(fields[3])
+			}
+		}
+	}
+
+	if f, err := os.Open(dhcpLeasesPath); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 3 && fields[2] == ip {
+				return normalizeMAC(fields[1])
+			}
+		}
+	}
+	return ""
+}
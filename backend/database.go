@@ -3,7 +3,10 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,6 +29,13 @@ func init() {
 // Using a mutex to prevent race conditions when reading/writing files
 var fileMutex = &sync.Mutex{}
 
+var errVoucherNotFound = errors.New("voucher not found")
+
+// cacheMutex guards vouchersCache and sessionsCache between the handlers that
+// change them and the background goroutines (reconciler, session restore)
+// that read them. Lock it before fileMutex, never after.
+var cacheMutex = &sync.RWMutex{}
+
 // In-memory cache for vouchers and settings
 var vouchersCache []Voucher
 var settingsCache map[string]string
@@ -37,13 +47,253 @@ type Voucher struct {
 	Duration   int       `json:"duration"` // in minutes
 	Price      float64   `json:"price,omitempty"`
 	CreatedAt  time.Time `json:"created_at,omitempty"`
-	Expiration time.Time `json:"expiration,omitempty"`
+	Expiration time.Time `json:"expiration,omitempty"` // absolute end of validity, see validUntil
 	DataLimit  int       `json:"data_limit,omitempty"` // in MB
-	IsReusable bool      `json:"is_reusable"`
+	IsReusable bool      `json:"is_reusable"`          // shared access code, see sessionExpiry
 	IsUsed     bool      `json:"is_used"`
 	StartTime  time.Time `json:"start_time,omitempty"`
 	UserIP     string    `json:"user_ip,omitempty"`
 	UserMAC    string    `json:"user_mac,omitempty"`
+	MaxDevices int       `json:"max_devices,omitempty"` // 0 = default (1, or unlimited when reusable)
+	// MaxRedemptions caps how many devices may ever redeem a shared code
+	// (0 = unlimited).
+	MaxRedemptions int `json:"max_redemptions,omitempty"`
+	// Revoked vouchers are refused everywhere and their devices are kicked
+	// out of NoDogSplash.
+	Revoked   bool      `json:"revoked,omitempty"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+	// TopUps extend the session funded by this voucher; AppliedTo is set on
+	// a voucher that was spent topping up another one instead.
+	TopUps    []TopUp `json:"top_ups,omitempty"`
+	AppliedTo int     `json:"applied_to,omitempty"`
+	// Devices holds every MAC bound to the voucher. UserIP/UserMAC keep the
+	// first device for older clients of the API.
+	Devices []VoucherDevice `json:"devices,omitempty"`
+	// Redemptions is the append-only history of devices redeeming the voucher.
+	// Unlike Devices it survives an admin unbinding a MAC.
+	Redemptions []Redemption `json:"redemptions,omitempty"`
+	// UploadRate and DownloadRate cap each device's bandwidth in kbit/s
+	// (0 = unlimited). NDS applies them when BinAuth lets the device in.
+	UploadRate   int `json:"upload_rate,omitempty"`
+	DownloadRate int `json:"download_rate,omitempty"`
+	// ActivateBy is the last moment an unused voucher can be redeemed, e.g.
+	// 30 days after sale. Lifetime is how long it stays valid after first
+	// use, in minutes, however much access time it has left. Zero values do
+	// not limit.
+	ActivateBy time.Time `json:"activate_by,omitempty"`
+	Lifetime   int       `json:"lifetime,omitempty"`
+	// AccessWindows limit when the voucher works, e.g. business hours; none
+	// means any time. Its clock keeps running outside them.
+	AccessWindows []AccessWindow `json:"access_windows,omitempty"`
+}
+
+// VoucherDevice is a client device bound to a voucher. For shared codes
+// BoundAt is also the start of that device's session.
+type VoucherDevice struct {
+	MAC     string    `json:"mac"`
+	IP      string    `json:"ip,omitempty"`
+	BoundAt time.Time `json:"bound_at"`
+}
+
+// TopUp records extra time or data added to a voucher's session and what
+// paid for it. On shared codes it only extends the session of MAC.
+type TopUp struct {
+	MAC      string    `json:"mac"`
+	Minutes  int       `json:"minutes,omitempty"`
+	DataMB   int       `json:"data_mb,omitempty"`
+	FundedBy string    `json:"funded_by"` // voucher code, or "admin"
+	Time     time.Time `json:"time"`
+}
+
+// Redemption records a single device redeeming a voucher.
+type Redemption struct {
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip,omitempty"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// deviceLimit returns how many devices may be bound to the voucher, or 0 when
+// there is no limit.
+func (v *Voucher) deviceLimit() int {
+	if v.MaxDevices > 0 {
+		return v.MaxDevices
+	}
+	if v.IsReusable {
+		return 0
+	}
+	return 1
+}
+
+// findDevice returns the bound device with the given MAC, or nil.
+func (v *Voucher) findDevice(mac string) *VoucherDevice {
+	mac = normalizeMAC(mac)
+	for i := range v.Devices {
+		if v.Devices[i].MAC == mac {
+			return &v.Devices[i]
+		}
+	}
+	return nil
+}
+
+// findRedemption returns the first redemption by the given MAC, or nil if it
+// never redeemed the voucher.
+func (v *Voucher) findRedemption(mac string) *Redemption {
+	mac = normalizeMAC(mac)
+	for i := range v.Redemptions {
+		if v.Redemptions[i].MAC == mac {
+			return &v.Redemptions[i]
+		}
+	}
+	return nil
+}
+
+// deviceLimitReached reports whether binding another, not yet bound, device
+// would exceed the voucher's limit. Devices whose own session has run out on
+// a shared code no longer take a slot.
+func (v *Voucher) deviceLimitReached() bool {
+	limit := v.deviceLimit()
+	if limit <= 0 {
+		return false
+	}
+	now := time.Now()
+	bound := 0
+	for i := range v.Devices {
+		if v.sessionRunning(&v.Devices[i], now) {
+			bound++
+		}
+	}
+	return bound >= limit
+}
+
+// redemptionLimitReached reports whether a shared code has been redeemed by
+// as many devices as its cap allows.
+func (v *Voucher) redemptionLimitReached() bool {
+	return v.IsReusable && v.MaxRedemptions > 0 && len(v.Redemptions) >= v.MaxRedemptions
+}
+
+// validUntil returns when the voucher stops being valid: the earlier of its
+// Expiration and Lifetime after first use, or the zero time when neither
+// applies. Top-ups do not move it.
+func (v *Voucher) validUntil() time.Time {
+	end := v.Expiration
+	if v.Lifetime > 0 && v.IsUsed && !v.StartTime.IsZero() {
+		if l := v.StartTime.Add(time.Duration(v.Lifetime) * time.Minute); end.IsZero() || l.Before(end) {
+			end = l
+		}
+	}
+	return end
+}
+
+// validityError returns the portal error code for a voucher that can no
+// longer be used at t because of its activation deadline, lifetime or
+// expiration, or "".
+func (v *Voucher) validityError(t time.Time) string {
+	if !v.IsUsed && !v.ActivateBy.IsZero() && t.After(v.ActivateBy) {
+		return "voucher_not_activated"
+	}
+	if end := v.validUntil(); !end.IsZero() && t.After(end) {
+		return "voucher_expired"
+	}
+	return ""
+}
+
+// sessionExpiry returns when access ends for a device bound to the voucher, or
+// the zero time if it has no time limit at all. Shared codes run a clock per
+// device; every other voucher shares the clock started on first use. Access
+// never outlasts the voucher's validity (validUntil), which is all that
+// limits a voucher without a duration.
+func (v *Voucher) sessionExpiry(d *VoucherDevice) time.Time {
+	if v.Duration <= 0 {
+		return v.validUntil()
+	}
+	start := v.StartTime
+	if v.IsReusable && d != nil {
+		start = d.BoundAt
+	}
+	if start.IsZero() {
+		return time.Time{}
+	}
+	expiry := start.Add(time.Duration(v.Duration) * time.Minute)
+	for _, t := range v.TopUps {
+		if v.IsReusable && (d == nil || t.MAC != d.MAC) {
+			continue
+		}
+		expiry = expiry.Add(time.Duration(t.Minutes) * time.Minute)
+	}
+	if end := v.validUntil(); !end.IsZero() && end.Before(expiry) {
+		expiry = end
+	}
+	return expiry
+}
+
+// sessionRunning reports whether the device's session has time left at now.
+// Sessions of vouchers without a duration never run out of time.
+func (v *Voucher) sessionRunning(d *VoucherDevice, now time.Time) bool {
+	expiry := v.sessionExpiry(d)
+	return expiry.IsZero() || now.Before(expiry)
+}
+
+// state returns the voucher's status for the admin UI (unused, active,
+// expired, revoked, or used when it was spent on a top-up) and when its access
+// ends: the latest running device session, the last one to end once all have,
+// or the end of its validity while unused. The time is zero when there is no
+// limit.
+func (v *Voucher) state(now time.Time) (string, time.Time) {
+	switch {
+	case v.Revoked:
+		return "revoked", time.Time{}
+	case v.AppliedTo != 0:
+		return "used", time.Time{}
+	case !v.IsUsed:
+		if v.validityError(now) != "" {
+			return "expired", v.validUntil()
+		}
+		return "unused", v.validUntil()
+	}
+	var last, latest time.Time
+	active, unlimited := false, false
+	for i := range v.Devices {
+		d := &v.Devices[i]
+		expiry := v.sessionExpiry(d)
+		if expiry.After(last) {
+			last = expiry
+		}
+		if !v.sessionRunning(d, now) || dataLimitReached(v, d, 0) {
+			continue
+		}
+		active = true
+		if expiry.IsZero() {
+			unlimited = true
+		} else if expiry.After(latest) {
+			latest = expiry
+		}
+	}
+	switch {
+	case !active:
+		return "expired", last
+	case unlimited:
+		return "active", time.Time{}
+	}
+	return "active", latest
+}
+
+// dataLimitFor returns the data allowance in MB for a bound device including
+// top-ups, or 0 when the voucher has no data limit.
+func (v *Voucher) dataLimitFor(d *VoucherDevice) int {
+	limit := v.DataLimit
+	for _, t := range v.TopUps {
+		if v.IsReusable && (d == nil || t.MAC != d.MAC) {
+			continue
+		}
+		limit += t.DataMB
+	}
+	return limit
+}
+
+// normalizeMAC lower-cases a MAC address so bindings compare reliably no
+// matter which component reported it.
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.TrimSpace(mac))
 }
 
 // loadData reads the voucher and settings JSON files into memory.
@@ -64,6 +314,7 @@ func loadData() error {
 		if err := json.Unmarshal(voucherData, &vouchersCache); err != nil {
 			return err
 		}
+		migrateVoucherDevices()
 	}
 
 	// Load settings
@@ -80,9 +331,37 @@ func loadData() error {
 			return err
 		}
 	}
+
 	return nil
 }
 
+// dataPath returns the location of a file inside the data directory.
+func dataPath(name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// readJSONFile decodes a JSON file into v. A missing file is not an error so
+// stores start out empty on first run.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile encodes v as indented JSON and writes it to path.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // saveData writes the in-memory caches back to their respective JSON files.
 func saveData() error {
 	fileMutex.Lock()
@@ -105,16 +384,56 @@ func saveData() error {
 	return os.WriteFile(settingsPath, settingsData, 0644)
 }
 
+// migrateVoucherDevices fills Devices and Redemptions for vouchers written
+// before multi-device support, which only recorded a single UserMAC.
+func migrateVoucherDevices() {
+	for i := range vouchersCache {
+		v := &vouchersCache[i]
+		if len(v.Devices) == 0 && v.UserMAC != "" {
+			v.Devices = []VoucherDevice{{MAC: normalizeMAC(v.UserMAC), IP: v.UserIP, BoundAt: v.StartTime}}
+		}
+		if len(v.Redemptions) == 0 {
+			for _, d := range v.Devices {
+				v.Redemptions = append(v.Redemptions, Redemption{MAC: d.MAC, IP: d.IP, StartTime: d.BoundAt})
+			}
+		}
+	}
+}
+
 func setupDatabase() error {
 	// Create the data directory if it doesn't exist
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return err
 	}
 	// Load existing data from files into memory
-	return loadData()
+	if err := loadData(); err != nil {
+		return err
+	}
+	if err := loadAuditLog(); err != nil {
+		return err
+	}
+	if err := loadSessions(); err != nil {
+		return err
+	}
+	if err := loadMACLists(); err != nil {
+		return err
+	}
+	if err := loadWalledGarden(); err != nil {
+		return err
+	}
+	if err := loadBranding(); err != nil {
+		return err
+	}
+	if err := loadTrial(); err != nil {
+		return err
+	}
+	return loadSchedule()
 }
 
 func addVoucher(voucher Voucher) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
 	// Find the highest existing ID to auto-increment
 	maxID := 0
 	for _, v := range vouchersCache {
@@ -135,21 +454,287 @@ func getVoucherByCode(code string) (*Voucher, error) {
 			return &vouchersCache[i], nil
 		}
 	}
-	return nil, errors.New("voucher not found")
+	return nil, errVoucherNotFound
 }
 
-func useVoucher(code, ip, mac string) error {
+// useVoucher binds a device to the voucher, starting the clock on first use.
+// Re-using the voucher from an already bound MAC only refreshes its IP. It
+// returns when the device's access ends (see sessionExpiry).
+func useVoucher(code, ip, mac string) (time.Time, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
 	v, err := getVoucherByCode(code)
 	if err != nil {
-		return err
+		return time.Time{}, err
+	}
+	if v.AppliedTo != 0 {
+		return time.Time{}, errVoucherToppedUp
 	}
 
-	v.IsUsed = true
-	v.StartTime = time.Now()
-	v.UserIP = ip
-	v.UserMAC = mac
+	mac = normalizeMAC(mac)
+	if mac == "" {
+		// An empty MAC would take a device slot that nothing can match.
+		return time.Time{}, errMACRequired
+	}
+	now := time.Now()
+	if d := v.findDevice(mac); d != nil {
+		d.IP = ip
+	} else {
+		if v.deviceLimitReached() {
+			return time.Time{}, fmt.Errorf("voucher device limit of %d reached", v.deviceLimit())
+		}
+		// A device unbound by an admin and bound again keeps its original
+		// clock and redemption instead of starting a fresh session.
+		if prev := v.findRedemption(mac); prev != nil {
+			v.Devices = append(v.Devices, VoucherDevice{MAC: mac, IP: ip, BoundAt: prev.StartTime})
+		} else {
+			if v.redemptionLimitReached() {
+				return time.Time{}, fmt.Errorf("voucher redemption limit of %d reached", v.MaxRedemptions)
+			}
+			v.Devices = append(v.Devices, VoucherDevice{MAC: mac, IP: ip, BoundAt: now})
+			v.Redemptions = append(v.Redemptions, Redemption{MAC: mac, IP: ip, StartTime: now})
+		}
+	}
 
-	return saveData()
+	if !v.IsUsed {
+		v.IsUsed = true
+		v.StartTime = now
+		v.UserIP = ip
+		v.UserMAC = mac
+	}
+
+	return v.sessionExpiry(v.findDevice(mac)), saveData()
+}
+
+// findActiveVoucher returns the voucher and device currently giving the MAC
+// access, or nil if it has no running session.
+func findActiveVoucher(mac string) (*Voucher, *VoucherDevice) {
+	mac = normalizeMAC(mac)
+	now := time.Now()
+	for i := range vouchersCache {
+		v := &vouchersCache[i]
+		if !v.IsUsed || v.Revoked {
+			continue
+		}
+		if d := v.findDevice(mac); d != nil && v.sessionRunning(d, now) {
+			return v, d
+		}
+	}
+	return nil, nil
+}
+
+var (
+	errMACRequired     = errors.New("a device MAC is required to use a voucher")
+	errNoActiveSession = errors.New("no active session for this device")
+	errSelfTopUp       = errors.New("a voucher cannot top up its own session")
+	errVoucherToppedUp = errors.New("voucher was spent on a top-up")
+	errTopUpCapped     = errors.New("the session already runs until its voucher ends")
+)
+
+// topUpSession adds minutes and/or data to the running session of a MAC. When
+// funding is non-nil that voucher is consumed and linked to the one extended;
+// nothing is spent on a top-up that could add nothing (errTopUpCapped). It
+// returns the extended voucher and the session's new expiry.
+func topUpSession(mac string, minutes, dataMB int, funding *Voucher) (*Voucher, time.Time, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	v, d := findActiveVoucher(mac)
+	if v == nil {
+		return nil, time.Time{}, errNoActiveSession
+	}
+
+	if funding != nil && funding.ID == v.ID {
+		return nil, time.Time{}, errSelfTopUp
+	}
+	// Time cannot outlast the voucher's validity, so a time-only top-up of a
+	// session already running until then would add nothing.
+	if dataMB <= 0 {
+		if end := v.validUntil(); !end.IsZero() && !v.sessionExpiry(d).Before(end) {
+			return nil, time.Time{}, errTopUpCapped
+		}
+	}
+
+	now := time.Now()
+	fundedBy := "admin"
+	if funding != nil {
+		fundedBy = funding.Code
+		funding.IsUsed = true
+		funding.StartTime = now
+		funding.AppliedTo = v.ID
+	}
+	v.TopUps = append(v.TopUps, TopUp{MAC: d.MAC, Minutes: minutes, DataMB: dataMB, FundedBy: fundedBy, Time: now})
+	return v, v.sessionExpiry(d), saveData()
+}
+
+// revokeVoucher marks a voucher as revoked and returns the MACs bound to it so
+// the caller can disconnect them.
+func revokeVoucher(id int) ([]string, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	v, err := getVoucherByID(id)
+	if err != nil {
+		return nil, err
+	}
+	v.Revoked = true
+	v.RevokedAt = time.Now()
+	macs := make([]string, 0, len(v.Devices))
+	for _, d := range v.Devices {
+		macs = append(macs, d.MAC)
+	}
+	return macs, saveData()
+}
+
+// unbindDevice removes a MAC from a voucher, freeing a slot for another device.
+func unbindDevice(id int, mac string) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	mac = normalizeMAC(mac)
+	for i := range vouchersCache {
+		v := &vouchersCache[i]
+		if v.ID != id {
+			continue
+		}
+		for j, d := range v.Devices {
+			if d.MAC == mac {
+				v.Devices = append(v.Devices[:j], v.Devices[j+1:]...)
+				if v.UserMAC == mac {
+					v.UserMAC, v.UserIP = "", ""
+					if len(v.Devices) > 0 {
+						v.UserMAC, v.UserIP = v.Devices[0].MAC, v.Devices[0].IP
+					}
+				}
+				return saveData()
+			}
+		}
+		return errors.New("device not bound to voucher")
+	}
+	return errVoucherNotFound
+}
+
+// VoucherUpdate carries the editable voucher fields. Nil fields are left
+// unchanged. Expiration and ActivateBy are RFC 3339 or a YYYY-MM-DD date;
+// "" clears them.
+type VoucherUpdate struct {
+	ID             int      `json:"id"`
+	Code           *string  `json:"code,omitempty"`
+	Name           *string  `json:"name,omitempty"`
+	Duration       *int     `json:"duration,omitempty"`
+	Price          *float64 `json:"price,omitempty"`
+	Expiration     *string  `json:"expiration,omitempty"`
+	ActivateBy     *string  `json:"activate_by,omitempty"`
+	Lifetime       *int     `json:"lifetime,omitempty"`
+	DataLimit      *int     `json:"data_limit,omitempty"`
+	UploadRate     *int     `json:"upload_rate,omitempty"`
+	DownloadRate   *int     `json:"download_rate,omitempty"`
+	IsReusable     *bool    `json:"is_reusable,omitempty"`
+	MaxDevices     *int     `json:"max_devices,omitempty"`
+	MaxRedemptions *int     `json:"max_redemptions,omitempty"`
+	// AccessWindows replaces the voucher's windows; an empty list removes them.
+	AccessWindows *[]AccessWindow `json:"access_windows,omitempty"`
+
+	expiration time.Time // parsed Expiration, set by validateVoucherUpdate
+	activateBy time.Time // parsed ActivateBy, likewise
+}
+
+// updateVoucher applies an already validated update, recording every changed
+// field in the audit log.
+func updateVoucher(u VoucherUpdate, actor string) (*Voucher, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	v, err := getVoucherByID(u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []AuditEntry
+	if u.Code != nil && *u.Code != v.Code {
+		changes = append(changes, auditField(v.ID, actor, "code", v.Code, *u.Code))
+		v.Code = *u.Code
+	}
+	if u.Name != nil && *u.Name != v.Name {
+		changes = append(changes, auditField(v.ID, actor, "name", v.Name, *u.Name))
+		v.Name = *u.Name
+	}
+	if u.Duration != nil && *u.Duration != v.Duration {
+		changes = append(changes, auditField(v.ID, actor, "duration", v.Duration, *u.Duration))
+		v.Duration = *u.Duration
+	}
+	if u.Price != nil && *u.Price != v.Price {
+		changes = append(changes, auditField(v.ID, actor, "price", v.Price, *u.Price))
+		v.Price = *u.Price
+	}
+	if u.Expiration != nil && !u.expiration.Equal(v.Expiration) {
+		changes = append(changes, auditField(v.ID, actor, "expiration", formatAuditTime(v.Expiration), formatAuditTime(u.expiration)))
+		v.Expiration = u.expiration
+	}
+	if u.ActivateBy != nil && !u.activateBy.Equal(v.ActivateBy) {
+		changes = append(changes, auditField(v.ID, actor, "activate_by", formatAuditTime(v.ActivateBy), formatAuditTime(u.activateBy)))
+		v.ActivateBy = u.activateBy
+	}
+	if u.Lifetime != nil && *u.Lifetime != v.Lifetime {
+		changes = append(changes, auditField(v.ID, actor, "lifetime", v.Lifetime, *u.Lifetime))
+		v.Lifetime = *u.Lifetime
+	}
+	if u.DataLimit != nil && *u.DataLimit != v.DataLimit {
+		changes = append(changes, auditField(v.ID, actor, "data_limit", v.DataLimit, *u.DataLimit))
+		v.DataLimit = *u.DataLimit
+	}
+	if u.UploadRate != nil && *u.UploadRate != v.UploadRate {
+		changes = append(changes, auditField(v.ID, actor, "upload_rate", v.UploadRate, *u.UploadRate))
+		v.UploadRate = *u.UploadRate
+	}
+	if u.DownloadRate != nil && *u.DownloadRate != v.DownloadRate {
+		changes = append(changes, auditField(v.ID, actor, "download_rate", v.DownloadRate, *u.DownloadRate))
+		v.DownloadRate = *u.DownloadRate
+	}
+	if u.IsReusable != nil && *u.IsReusable != v.IsReusable {
+		changes = append(changes, auditField(v.ID, actor, "is_reusable", v.IsReusable, *u.IsReusable))
+		v.IsReusable = *u.IsReusable
+	}
+	if u.MaxDevices != nil && *u.MaxDevices != v.MaxDevices {
+		changes = append(changes, auditField(v.ID, actor, "max_devices", v.MaxDevices, *u.MaxDevices))
+		v.MaxDevices = *u.MaxDevices
+	}
+	if u.MaxRedemptions != nil && *u.MaxRedemptions != v.MaxRedemptions {
+		changes = append(changes, auditField(v.ID, actor, "max_redemptions", v.MaxRedemptions, *u.MaxRedemptions))
+		v.MaxRedemptions = *u.MaxRedemptions
+	}
+	if u.AccessWindows != nil {
+		if before, after := formatAccessWindows(v.AccessWindows), formatAccessWindows(*u.AccessWindows); before != after {
+			changes = append(changes, auditField(v.ID, actor, "access_windows", before, after))
+			v.AccessWindows = *u.AccessWindows
+		}
+	}
+
+	if len(changes) == 0 {
+		return v, nil
+	}
+	if err := saveData(); err != nil {
+		return nil, err
+	}
+	return v, recordAudit(changes...)
+}
+
+// formatAuditTime renders a time for the audit log, using "none" for unset.
+func formatAuditTime(t time.Time) string {
+	if t.IsZero() {
+		return "none"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func getVoucherByID(id int) (*Voucher, error) {
+	for i, v := range vouchersCache {
+		if v.ID == id {
+			return &vouchersCache[i], nil
+		}
+	}
+	return nil, errVoucherNotFound
 }
 
 func getVouchers() ([]Voucher, error) {
@@ -165,6 +750,9 @@ func getVouchers() ([]Voucher, error) {
 }
 
 func deleteVoucher(id int) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
 	found := false
 	var indexToDelete int
 	for i, v := range vouchersCache {
@@ -176,7 +764,7 @@ func deleteVoucher(id int) error {
 	}
 
 	if !found {
-		return errors.New("voucher not found")
+		return errVoucherNotFound
 	}
 
 	vouchersCache = append(vouchersCache[:indexToDelete], vouchersCache[indexToDelete+1:]...)
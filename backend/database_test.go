@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpentTopUpVoucherCannotBeRedeemed(t *testing.T) {
+	useFakeNDS(t, runningVoucher(1, testMAC, 60), Voucher{ID: 2, Code: "TOPUP", Duration: 30})
+
+	funding, perr := validateTopUpVoucher("TOPUP")
+	if perr != nil {
+		t.Fatalf("validateTopUpVoucher: %s", perr)
+	}
+	if _, _, err := topUpSession(testMAC, funding.Duration, 0, funding); err != nil {
+		t.Fatalf("topUpSession: %v", err)
+	}
+
+	if _, perr := validateVoucher("TOPUP", otherMAC); perr == nil || perr.Code != "voucher_topped_up" {
+		t.Errorf("validateVoucher = %v, want voucher_topped_up", perr)
+	}
+	if _, err := useVoucher("TOPUP", "10.0.0.3", otherMAC); err != errVoucherToppedUp {
+		t.Errorf("useVoucher error = %v, want %v", err, errVoucherToppedUp)
+	}
+	if _, perr := validateTopUpVoucher("TOPUP"); perr == nil || perr.Code != "voucher_topped_up" {
+		t.Errorf("validateTopUpVoucher = %v, want voucher_topped_up", perr)
+	}
+}
+
+func TestTopUpRefusedWhenValidityLeavesNoRoom(t *testing.T) {
+	v := runningVoucher(1, testMAC, 60)
+	v.Expiration = time.Now().Add(30 * time.Minute)
+	useFakeNDS(t, v, Voucher{ID: 2, Code: "TOPUP", Duration: 30})
+	funding, _ := getVoucherByCode("TOPUP")
+
+	if _, _, err := topUpSession(testMAC, funding.Duration, 0, funding); err != errTopUpCapped {
+		t.Fatalf("topUpSession error = %v, want %v", err, errTopUpCapped)
+	}
+	if funding.IsUsed || funding.AppliedTo != 0 {
+		t.Errorf("funding = %+v, want it left unspent", funding)
+	}
+	// Data still has room to grow.
+	if _, _, err := topUpSession(testMAC, 0, 100, nil); err != nil {
+		t.Errorf("data top-up: %v", err)
+	}
+}
+
+func TestVoucherState(t *testing.T) {
+	now := time.Now()
+	capped := runningVoucher(3, testMAC, 600)
+	capped.Lifetime = 30
+	shared := runningVoucher(4, testMAC, 60)
+	shared.IsReusable = true
+	shared.Devices[0].BoundAt = now.Add(-2 * time.Hour)
+	shared.Devices = append(shared.Devices, VoucherDevice{MAC: otherMAC, BoundAt: now.Add(-time.Minute)})
+	useFakeNDS(t)
+
+	tests := []struct {
+		name    string
+		v       Voucher
+		status  string
+		expires time.Time
+	}{
+		{"spent on a top-up", Voucher{IsUsed: true, AppliedTo: 1, StartTime: now}, "used", time.Time{}},
+		{"no time limit", runningVoucher(1, testMAC, 0), "active", time.Time{}},
+		{"capped by lifetime", capped, "active", capped.StartTime.Add(30 * time.Minute)},
+		{"shared with one device left", shared, "active", shared.Devices[1].BoundAt.Add(time.Hour)},
+		{"unused past its end", Voucher{Duration: 60, Expiration: now.Add(-time.Hour)}, "expired", now.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		status, expires := tt.v.state(now)
+		if status != tt.status || !expires.Equal(tt.expires) {
+			t.Errorf("%s: state = %s, %v; want %s, %v", tt.name, status, expires, tt.status, tt.expires)
+		}
+	}
+}
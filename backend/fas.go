@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fasSessionTTL is how long a client has to enter a voucher after NDS sent
+// it to the portal.
+const fasSessionTTL = 10 * time.Minute
+
+// fasSession ties a portal visit to the client NDS redirected, so staging
+// uses the MAC NDS reported rather than anything the browser sends.
+type fasSession struct {
+	MAC     string
+	IP      string
+	AuthURL string // where the browser completes auth once staged
+	Created time.Time
+}
+
+var (
+	fasSessions      = make(map[string]*fasSession)
+	fasSessionsMutex = &sync.Mutex{}
+)
+
+var (
+	errFASTokenMissing = errors.New("no client token in request")
+	errFASUnknownToken = errors.New("token does not match a client waiting at the portal")
+	errFASNoKey        = errors.New("secure FAS request but no faskey is configured")
+)
+
+// fasKey returns the key shared with NDS for secure FAS levels. It comes from
+// the NoDogSplash or openNDS config, or VOUCHER_FAS_KEY in dev.
+func fasKey() string {
+	if key := os.Getenv("VOUCHER_FAS_KEY"); key != "" {
+		return key
+	}
+	out, err := exec.Command("uci", "-q", "get", daemon.uciOption("faskey")).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// fasParams collects the FAS query parameters. On secure level 2 they arrive
+// base64-encoded in the fas parameter as "key=value, key=value" pairs; on
+// openNDS level 3 that string is also AES-256-CBC encrypted with the faskey,
+// with the IV in the iv parameter.
+func fasParams(q url.Values) (map[string]string, error) {
+	params := make(map[string]string)
+	for k := range q {
+		params[k] = q.Get(k)
+	}
+	blob := q.Get("fas")
+	if blob == "" {
+		return params, nil
+	}
+
+	var decoded []byte
+	var err error
+	if iv := q.Get("iv"); iv != "" {
+		key := fasKey()
+		if key == "" {
+			return nil, errFASNoKey
+		}
+		decoded, err = decryptFAS(blob, iv, key)
+	} else {
+		decoded, err = base64.StdEncoding.DecodeString(blob)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding fas parameter: %v", err)
+	}
+	for _, pair := range strings.Split(string(decoded), ", ") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			v = strings.TrimSpace(v)
+			if unescaped, err := url.QueryUnescape(v); err == nil {
+				v = unescaped // originurl is URL-encoded
+			}
+			params[strings.TrimSpace(k)] = v
+		}
+	}
+	return params, nil
+}
+
+// decryptFAS decrypts an openNDS level 3 fas parameter. openNDS encrypts the
+// query the way PHP's openssl_encrypt does: the faskey is zero-padded to a
+// 32-byte AES-256 key, the ciphertext is PKCS#7 padded and base64-encoded,
+// and the result is base64-encoded once more for the URL.
+func decryptFAS(blob, iv, key string) ([]byte, error) {
+	outer, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(outer)))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("iv must be %d bytes", aes.BlockSize)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a whole number of blocks")
+	}
+
+	k := make([]byte, 32)
+	copy(k, key)
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, []byte(iv)).CryptBlocks(data, data)
+
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(data) {
+		return nil, errors.New("bad padding, wrong faskey?")
+	}
+	return data[:len(data)-pad], nil
+}
+
+// verifyFASClient finds the NDS client a FAS request is for. Level 0 sends
+// the client token itself; secure levels send hid = sha256(token + faskey),
+// so the token never travels in the clear. Either way the client must be one
+// NDS currently knows about, and its MAC is taken from NDS. It returns the
+// client and the value to pass back to NDS as tok when completing auth.
+func verifyFASClient(params map[string]string) (*ndsClientInfo, string, error) {
+	tok, hid := params["tok"], params["hid"]
+	if tok == "" && hid == "" {
+		return nil, "", errFASTokenMissing
+	}
+	key := ""
+	if tok == "" {
+		if key = fasKey(); key == "" {
+			return nil, "", errFASNoKey
+		}
+	}
+
+	clients, err := nds.Clients()
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range clients {
+		c := &clients[i]
+		if c.Token == "" {
+			continue
+		}
+		if tok != "" && c.Token == tok {
+			return c, tok, nil
+		}
+		if hid != "" && sha256Hex(c.Token+key) == hid {
+			return c, sha256Hex(hid + key), nil
+		}
+	}
+	return nil, "", errFASUnknownToken
+}
+
+// fasAuthURL builds the URL that completes auth in NDS. NDS passes its
+// authaction directly on level 0; secure levels pass the gateway address and
+// auth directory instead.
+func fasAuthURL(r *http.Request, params map[string]string, tok string) string {
+	action := params["authaction"]
+	if action == "" && params["gatewayaddress"] != "" {
+		dir := params["authdir"]
+		if dir == "" {
+			dir = daemon.authDir()
+		}
+		action = "http://" + params["gatewayaddress"] + "/" + strings.Trim(dir, "/") + "/"
+	}
+	if action == "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		action = "http://" + host + ":2050/" + daemon.authDir() + "/"
+	}
+
+	q := url.Values{"tok": {tok}}
+	redir := params["redir"]
+	if redir == "" {
+		redir = params["originurl"]
+	}
+	if redir != "" {
+		q.Set("redir", redir)
+	}
+	return action + "?" + q.Encode()
+}
+
+func newFASSession(s *fasSession) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	fasSessionsMutex.Lock()
+	defer fasSessionsMutex.Unlock()
+	for sid, old := range fasSessions {
+		if time.Since(old.Created) > fasSessionTTL {
+			delete(fasSessions, sid)
+		}
+	}
+	s.Created = time.Now()
+	fasSessions[id] = s
+	return id, nil
+}
+
+// getFASSession returns the session for id, or nil if unknown or expired.
+func getFASSession(id string) *fasSession {
+	fasSessionsMutex.Lock()
+	defer fasSessionsMutex.Unlock()
+	s, ok := fasSessions[id]
+	if !ok || time.Since(s.Created) > fasSessionTTL {
+		return nil
+	}
+	return s
+}
+
+// remoteIP returns the IP address the request came from.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ndsClientByIP returns the NDS client with the given IP address, or nil.
+func ndsClientByIP(ip string) (*ndsClientInfo, error) {
+	clients, err := nds.Clients()
+	if err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if clients[i].IP == ip {
+			return &clients[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Customer-facing messages live in locales/<lang>.json, keyed by a stable
+// code. API errors carry the code next to the translated text so clients can
+// react to them without matching on wording. Admin responses stay English.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// supportedLanguages lists the catalogs in locales/, English first.
+var supportedLanguages = []string{"en", "bn", "hi"}
+
+// langCookieName remembers a ?lang= choice for the portal's API calls.
+const langCookieName = "voucher-lang"
+
+var catalogs = func() map[string]map[string]string {
+	catalogs := map[string]map[string]string{}
+	for _, lang := range supportedLanguages {
+		data, err := localeFiles.ReadFile("locales/" + lang + ".json")
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("locales/%s.json: %v", lang, err))
+		}
+		catalogs[lang] = messages
+	}
+	return catalogs
+}()
+
+func isSupportedLanguage(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// defaultLanguage is the admin's default_language setting, used when the
+// browser asks for none of the supported languages.
+func defaultLanguage() string {
+	if lang, _ := getSetting("default_language"); isSupportedLanguage(lang) {
+		return lang
+	}
+	return "en"
+}
+
+// requestLanguage picks the language for a customer request: ?lang=, then
+// the cookie set by an earlier ?lang=, then Accept-Language, then the
+// default language.
+func requestLanguage(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); isSupportedLanguage(lang) {
+		return lang
+	}
+	if c, err := r.Cookie(langCookieName); err == nil && isSupportedLanguage(c.Value) {
+		return c.Value
+	}
+	if lang := acceptLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return lang
+	}
+	return defaultLanguage()
+}
+
+// acceptLanguage returns the supported language the Accept-Language header
+// prefers most, matching on the primary subtag ("bn-BD" is "bn"), or "".
+func acceptLanguage(header string) string {
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > 0 && isSupportedLanguage(primary) {
+			prefs = append(prefs, pref{primary, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	if len(prefs) == 0 {
+		return ""
+	}
+	return prefs[0].lang
+}
+
+// rememberLanguage keeps an explicit ?lang= choice in a cookie, so the
+// portal page's later API calls answer in the same language.
+func rememberLanguage(w http.ResponseWriter, r *http.Request) {
+	if lang := r.URL.Query().Get("lang"); isSupportedLanguage(lang) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     langCookieName,
+			Value:    lang,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// translate returns the message for code in lang, falling back to English
+// and then to the code itself. Arguments fill the message's verbs.
+func translate(lang, code string, args ...interface{}) string {
+	msg, ok := catalogs[lang][code]
+	if !ok {
+		if msg, ok = catalogs["en"][code]; !ok {
+			msg = code
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// portalText is the catalog for lang with English filling any gaps, for
+// theme templates.
+func portalText(lang string) map[string]string {
+	text := make(map[string]string, len(catalogs["en"]))
+	for code, msg := range catalogs["en"] {
+		text[code] = msg
+	}
+	for code, msg := range catalogs[lang] {
+		text[code] = msg
+	}
+	return text
+}
+
+// portalError is a customer-facing error: a code from the catalogs plus the
+// arguments its message needs.
+type portalError struct {
+	Code string
+	Args []interface{}
+}
+
+func newPortalError(code string, args ...interface{}) *portalError {
+	return &portalError{Code: code, Args: args}
+}
+
+// Error returns the English message, for logs and the admin API.
+func (e *portalError) Error() string {
+	return translate("en", e.Code, e.Args...)
+}
+
+// writePortalError replies {"error": <translated message>, "code": <code>}.
+func writePortalError(w http.ResponseWriter, r *http.Request, status int, e *portalError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": translate(requestLanguage(r), e.Code, e.Args...),
+		"code":  e.Code,
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// MAC list names. Trusted MACs bypass the portal entirely, blocked MACs get no
+// access at all, and allowed MACs are the only ones let through when
+// NoDogSplash runs with `macmechanism allow`.
+const (
+	macListTrusted = "trusted"
+	macListBlocked = "blocked"
+	macListAllowed = "allowed"
+)
+
+// ListedMAC is an entry in one of the managed MAC lists.
+type ListedMAC struct {
+	MAC     string    `json:"mac"`
+	Note    string    `json:"note,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// MACLists holds the MAC lists managed from the admin panel.
+type MACLists struct {
+	Trusted []ListedMAC `json:"trusted"`
+	Blocked []ListedMAC `json:"blocked"`
+	Allowed []ListedMAC `json:"allowed"`
+}
+
+var macListsCache MACLists
+
+func loadMACLists() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	macListsCache = MACLists{Trusted: []ListedMAC{}, Blocked: []ListedMAC{}, Allowed: []ListedMAC{}}
+	return readJSONFile(dataPath("macs.json"), &macListsCache)
+}
+
+func saveMACLists() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return writeJSONFile(dataPath("macs.json"), macListsCache)
+}
+
+// macList returns the named list, or nil for an unknown name.
+func macList(name string) *[]ListedMAC {
+	switch name {
+	case macListTrusted:
+		return &macListsCache.Trusted
+	case macListBlocked:
+		return &macListsCache.Blocked
+	case macListAllowed:
+		return &macListsCache.Allowed
+	}
+	return nil
+}
+
+// isListed reports whether a MAC is on the named list.
+func isListed(name, mac string) bool {
+	list := macList(name)
+	if list == nil {
+		return false
+	}
+	for _, e := range *list {
+		if e.MAC == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// applyListedMAC adds (on=true) or removes a MAC from the named list in
+// NoDogSplash.
+func applyListedMAC(name, mac string, on bool) error {
+	switch name {
+	case macListTrusted:
+		if on {
+			return nds.Trust(mac)
+		}
+		return nds.Untrust(mac)
+	case macListBlocked:
+		if on {
+			return nds.Block(mac)
+		}
+		return nds.Unblock(mac)
+	case macListAllowed:
+		if on {
+			return nds.Allow(mac)
+		}
+		return nds.Unallow(mac)
+	}
+	return fmt.Errorf("unknown MAC list '%s'", name)
+}
+
+// addListedMAC validates a MAC, stores it on the named list and applies it to
+// NoDogSplash straight away. A failure to reach NDS is logged, not returned:
+// the entry is persisted and will be applied on the next start.
+func addListedMAC(name, mac, note string) error {
+	list := macList(name)
+	if list == nil {
+		return fmt.Errorf("unknown MAC list '%s'", name)
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return errors.New("invalid MAC address")
+	}
+	mac = normalizeMAC(hw.String())
+	if isListed(name, mac) {
+		return errors.New("MAC is already on this list")
+	}
+	if (name == macListTrusted && isListed(macListBlocked, mac)) || (name == macListBlocked && isListed(macListTrusted, mac)) {
+		return errors.New("MAC cannot be both trusted and blocked")
+	}
+
+	*list = append(*list, ListedMAC{MAC: mac, Note: note, AddedAt: time.Now()})
+	if err := saveMACLists(); err != nil {
+		return err
+	}
+	if err := applyListedMAC(name, mac, true); err != nil {
+		log.Printf("[macLists] Saved %s MAC %s but could not apply it: %v", name, mac, err)
+	}
+	return nil
+}
+
+// removeListedMAC removes a MAC from the named list and from NoDogSplash.
+func removeListedMAC(name, mac string) error {
+	list := macList(name)
+	if list == nil {
+		return fmt.Errorf("unknown MAC list '%s'", name)
+	}
+	mac = normalizeMAC(mac)
+	for i, e := range *list {
+		if e.MAC == mac {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			if err := saveMACLists(); err != nil {
+				return err
+			}
+			if err := applyListedMAC(name, mac, false); err != nil {
+				log.Printf("[macLists] Removed %s MAC %s but could not apply it: %v", name, mac, err)
+			}
+			return nil
+		}
+	}
+	return errors.New("MAC is not on this list")
+}
+
+// applyMACLists pushes every managed MAC into NoDogSplash. NDS forgets
+// runtime trust/block changes when it restarts, so this runs at startup and
+// waits (retryNDS) in case NDS comes up after the backend.
+func applyMACLists() {
+	if _, err := nds.Status(); err == errNDSUnavailable {
+		return
+	}
+	var err error
+	ready := retryNDS(func() bool {
+		_, err = nds.Status()
+		return err == nil
+	})
+	if !ready {
+		log.Printf("[applyMACLists] NoDogSplash not ready, giving up: %v", err)
+		return
+	}
+
+	count := 0
+	for _, name := range []string{macListTrusted, macListBlocked, macListAllowed} {
+		for _, e := range *macList(name) {
+			if err := applyListedMAC(name, e.MAC, true); err != nil {
+				log.Printf("[applyMACLists] Failed to apply %s MAC %s: %v", name, e.MAC, err)
+				continue
+			}
+			count++
+		}
+	}
+	log.Printf("[applyMACLists] Applied %d managed MAC(s) to NoDogSplash.", count)
+}
@@ -5,27 +5,30 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 )
 
 var sessionCookieName = "voucher-admin-session"
-var frontendDir = "frontend"
 
-// For BinAuth: an in-memory store to stage client authentications.
-var stagedAuths = make(map[string]int)
-var stagedAuthsMutex = &sync.Mutex{}
+// frontendDir overrides the embedded portal files (see web.go) and holds
+// uploaded themes. It need not exist.
+var frontendDir = "frontend"
 
 func init() {
 	// Check if we are on the router (production) or local (dev)
 	if _, err := os.Stat("/www/voucher"); err == nil {
 		frontendDir = "/www/voucher"
 	}
+	if dir := os.Getenv("VOUCHER_WEB_DIR"); dir != "" {
+		frontendDir = dir
+	}
 }
 
 func generateVoucherCode() (string, error) {
@@ -69,22 +72,68 @@ func main() {
 		log.Fatalf("Failed to initialize admin password: %v", err)
 	}
 
-	restageActiveUsers()
+	// Expire staged authentications nobody completed.
+	go runStagedAuthJanitor()
+
+	// Re-apply the trusted/blocked/allowed MACs managed from the admin panel;
+	// NoDogSplash only knows about them through ndsctl at runtime.
+	go applyMACLists()
 
 	// Restore active sessions into NoDogSplash after a reboot so reconnecting
 	// devices skip the splash entirely. Runs in the background because it polls
 	// for devices to come back online over a few minutes.
 	go reauthSessionsViaNDS()
 
+	// Keep NoDogSplash in line with the vouchers: kick clients whose voucher
+	// expired or was revoked, and restore ones that should still be online.
+	go runSessionReconciler()
+
+	// Walled-garden hostnames are re-resolved so the rules follow their
+	// current addresses.
+	go runWalledGardenRefresher()
+
 	// Setup routes
+	http.HandleFunc("/fas", fasHandler)
+	http.HandleFunc("/captive-portal/api", capportHandler)
 	http.HandleFunc("/binauth-stage", binauthStageHandler)
 	http.HandleFunc("/binauth-check", binauthCheckHandler)
+	http.HandleFunc("/binauth-event", binauthEventHandler)
 	http.HandleFunc("/auth", authHandler)
+	http.HandleFunc("/topup", topUpHandler)
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/check", checkHandler)
+	http.HandleFunc("/logout", logoutHandler)
+	http.HandleFunc("/branding/logo", logoHandler)
+	http.HandleFunc("/terms", termsHandler)
+	http.HandleFunc("/trial", trialHandler)
 
 	// Admin routes
 	http.HandleFunc("/admin/login", adminLoginHandler)
 	http.HandleFunc("/admin/add", authMiddleware(adminAddHandler))
 	http.HandleFunc("/admin/delete", authMiddleware(adminDeleteHandler))
+	http.HandleFunc("/admin/unbind", authMiddleware(adminUnbindHandler))
+	http.HandleFunc("/admin/update", authMiddleware(adminUpdateHandler))
+	http.HandleFunc("/admin/audit", authMiddleware(adminAuditHandler))
+	http.HandleFunc("/admin/sessions", authMiddleware(adminSessionsHandler))
+	http.HandleFunc("/admin/revoke", authMiddleware(adminRevokeHandler))
+	http.HandleFunc("/admin/disconnect", authMiddleware(adminDisconnectHandler))
+	http.HandleFunc("/admin/topup", authMiddleware(adminTopUpHandler))
+	http.HandleFunc("/admin/clients", authMiddleware(adminClientsHandler))
+	http.HandleFunc("/admin/block", authMiddleware(adminBlockHandler))
+	http.HandleFunc("/admin/unblock", authMiddleware(adminBlockHandler))
+	http.HandleFunc("/admin/macs", authMiddleware(adminMACListsHandler))
+	http.HandleFunc("/admin/macs/add", authMiddleware(adminMACListEditHandler))
+	http.HandleFunc("/admin/macs/remove", authMiddleware(adminMACListEditHandler))
+	http.HandleFunc("/admin/walled-garden", authMiddleware(adminWalledGardenHandler))
+	http.HandleFunc("/admin/branding", authMiddleware(adminBrandingHandler))
+	http.HandleFunc("/admin/branding/logo", authMiddleware(adminLogoHandler))
+	http.HandleFunc("/admin/preview", authMiddleware(adminPreviewHandler))
+	http.HandleFunc("/admin/themes", authMiddleware(adminThemesHandler))
+	http.HandleFunc("/admin/themes/upload", authMiddleware(adminThemeUploadHandler))
+	http.HandleFunc("/admin/themes/delete", authMiddleware(adminThemeDeleteHandler))
+	http.HandleFunc("/admin/trial", authMiddleware(adminTrialHandler))
+	http.HandleFunc("/admin/schedule", authMiddleware(adminScheduleHandler))
+	http.HandleFunc("/admin/trial/acceptances", authMiddleware(adminTermsAcceptancesHandler))
 	http.HandleFunc("/admin/vouchers", authMiddleware(adminVouchersHandler))
 	http.HandleFunc("/admin/change-password", authMiddleware(adminChangePasswordHandler))
 	http.HandleFunc("/admin/logout", adminLogoutHandler)
@@ -95,94 +144,321 @@ func main() {
 	// Serve the portal with theme support
 	http.HandleFunc("/", rootHandler)
 
-	log.Printf("Starting server on :7891, serving from %s", frontendDir)
+	log.Printf("Starting server on :7891, serving embedded files with overrides from %s", frontendDir)
 	if err := http.ListenAndServe(":7891", nil); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
-	// Serve static files (admin.html, admin.js, etc.)
+	// Serve static files (admin.html, the admin SPA, theme assets)
 	if r.URL.Path != "/" && r.URL.Path != "/index.html" {
-		http.FileServer(http.Dir(frontendDir)).ServeHTTP(w, r)
+		staticHandler(w, r)
 		return
 	}
 
-	// For the root or index.html, serve the themed template
-	theme, err := getSetting("active_theme")
-	if err != nil || theme == "" {
+	// For the root or index.html, render the active theme with the branding
+	theme, _ := getSetting("active_theme")
+	if themePath(theme) == "" {
 		theme = "default"
 	}
+	rememberLanguage(w, r)
+	renderTheme(w, r, theme)
+}
+
+// adminPreviewHandler renders any theme with the current branding, so a theme
+// can be checked before it is activated.
+func adminPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	theme := r.URL.Query().Get("theme")
+	if themePath(theme) == "" {
+		http.Error(w, "Theme not found", http.StatusNotFound)
+		return
+	}
+	renderTheme(w, r, theme)
+}
+
+func adminBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(brandingCache)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b Branding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if errMsg := validateBranding(&b); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
+	// The logo is only set through the upload endpoint; here it can just be
+	// cleared.
+	if b.Logo != "" {
+		b.Logo = brandingCache.Logo
+	}
 
-	themePath := fmt.Sprintf("%s/themes/%s.html", frontendDir, theme)
-	if _, err := os.Stat(themePath); os.IsNotExist(err) {
-		themePath = fmt.Sprintf("%s/themes/default.html", frontendDir)
+	old := brandingCache
+	brandingCache = b
+	if err := saveBranding(); err != nil {
+		brandingCache = old
+		http.Error(w, `{"error": "Could not save branding"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(brandingCache)
+}
+
+// adminLogoHandler takes a multipart upload in the "logo" field.
+func adminLogoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoBytes+4096)
+	file, _, err := r.FormFile("logo")
+	if err != nil {
+		http.Error(w, `{"error": "Logo missing or larger than 512 KB"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxLogoBytes+1))
+	if err != nil || len(data) > maxLogoBytes {
+		http.Error(w, `{"error": "Logo must be at most 512 KB"}`, http.StatusBadRequest)
+		return
 	}
 
-	http.ServeFile(w, r, themePath)
+	logo, err := saveLogo(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	old := brandingCache.Logo
+	brandingCache.Logo = logo
+	if err := saveBranding(); err != nil {
+		brandingCache.Logo = old
+		http.Error(w, `{"error": "Could not save branding"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(brandingCache)
 }
 
-func validateVoucher(voucherCode string) (*Voucher, string) {
+// validateVoucher checks whether the voucher may be used by the given MAC.
+// Devices already bound to the voucher are always let back in until it
+// expires; new devices are refused once the device limit is reached.
+func validateVoucher(voucherCode, mac string) (*Voucher, *portalError) {
 	if voucherCode == "" {
-		return nil, "Voucher code is required"
+		return nil, newPortalError("voucher_required")
 	}
 
 	voucher, err := getVoucherByCode(voucherCode)
 	if err != nil {
-		return nil, "Invalid voucher code"
+		return nil, newPortalError("voucher_invalid")
+	}
+	// A code spent on a top-up has no session of its own to join.
+	if voucher.AppliedTo != 0 {
+		return nil, newPortalError("voucher_topped_up")
 	}
 
-	if !voucher.IsReusable && voucher.IsUsed {
-		return nil, "Voucher has already been used"
+	device := voucher.findDevice(mac)
+	redeemed := voucher.findRedemption(mac)
+	if voucher.IsUsed && device == nil {
+		if voucher.deviceLimitReached() {
+			if voucher.deviceLimit() == 1 {
+				return nil, newPortalError("voucher_used")
+			}
+			return nil, newPortalError("voucher_device_limit", voucher.deviceLimit())
+		}
+		if redeemed == nil && voucher.redemptionLimitReached() {
+			return nil, newPortalError("voucher_redemption_limit")
+		}
 	}
 
-	if !voucher.Expiration.IsZero() && time.Now().After(voucher.Expiration) {
-		return nil, "Voucher has expired"
+	if voucher.Revoked {
+		return nil, newPortalError("voucher_revoked")
 	}
 
-	if voucher.IsUsed && voucher.Duration > 0 {
-		if time.Since(voucher.StartTime).Minutes() > float64(voucher.Duration) {
-			return nil, "Voucher access duration has expired"
+	if code := voucher.validityError(time.Now()); code != "" {
+		return nil, newPortalError(code)
+	}
+
+	if portalClosed(time.Now()) {
+		return nil, newPortalError("portal_closed")
+	}
+	if !voucher.inAccessHours(time.Now()) {
+		return nil, newPortalError("voucher_outside_hours")
+	}
+
+	// A new device on a shared code starts its own clock, so only bound
+	// devices and single-clock vouchers can have run out of time. A device
+	// an admin unbound comes back on the clock it had.
+	if device == nil && redeemed != nil {
+		device = &VoucherDevice{MAC: redeemed.MAC, BoundAt: redeemed.StartTime}
+	}
+	if voucher.IsUsed && (device != nil || !voucher.IsReusable) {
+		if expiry := voucher.sessionExpiry(device); !expiry.IsZero() && time.Now().After(expiry) {
+			return nil, newPortalError("voucher_time_up")
 		}
 	}
 
-	return voucher, ""
+	if voucher.IsUsed && (device != nil || !voucher.IsReusable) && dataLimitReached(voucher, device, 0) {
+		return nil, newPortalError("voucher_data_limit")
+	}
+
+	return voucher, nil
 }
 
+// authHandler is the legacy login endpoint. Like /binauth-stage it binds the
+// voucher to the client behind the request, never to a MAC the browser names.
 func authHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	voucherCode := r.URL.Query().Get("voucher")
-	clientIP := r.URL.Query().Get("ip")
-	clientMAC := r.URL.Query().Get("mac")
+	clientMAC, clientIP, _, ok := portalClient(w, r)
+	if !ok {
+		return
+	}
 
-	voucher, errMsg := validateVoucher(voucherCode)
-	if errMsg != "" {
-		log.Printf("Auth validation failed for voucher '%s': %s", voucherCode, errMsg)
-		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusUnauthorized)
+	voucher, perr := validateVoucher(voucherCode, clientMAC)
+	if perr != nil {
+		log.Printf("Auth validation failed for voucher '%s': %s", voucherCode, perr)
+		writePortalError(w, r, http.StatusUnauthorized, perr)
 		return
 	}
 
-	if !voucher.IsUsed {
-		err := useVoucher(voucher.Code, clientIP, clientMAC)
-		if err != nil {
-			log.Printf("Error marking voucher as used: %v", err)
-			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
-			return
-		}
+	firstUse := !voucher.IsUsed
+	expiry, err := useVoucher(voucher.Code, clientIP, clientMAC)
+	if err != nil {
+		log.Printf("Error marking voucher as used: %v", err)
+		writePortalError(w, r, http.StatusInternalServerError, newPortalError("internal_error"))
+		return
+	}
+	setKicked(clientMAC, false)
+	if firstUse {
 		log.Printf("First use of voucher '%s' by MAC %s", voucher.Code, clientMAC)
 	} else {
 		log.Printf("Repeat use of voucher '%s' by MAC %s", voucher.Code, clientMAC)
 	}
 
-	log.Printf("Successfully authenticated voucher %s for MAC %s, providing %d minutes.", voucher.Code, clientMAC, voucher.Duration)
+	seconds, _ := activeSession{MAC: clientMAC, Expiry: expiry}.remaining(time.Now())
+	log.Printf("Successfully authenticated voucher %s for MAC %s, providing %d minutes.", voucher.Code, clientMAC, (seconds+59)/60)
 
 	response := map[string]interface{}{
 		"status":   "success",
-		"duration": voucher.Duration,
+		"duration": (seconds + 59) / 60,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// validateTopUpVoucher checks that a voucher can be spent extending another
+// session: it must be unused, unexpired and not a shared code.
+func validateTopUpVoucher(voucherCode string) (*Voucher, *portalError) {
+	if voucherCode == "" {
+		return nil, newPortalError("voucher_required")
+	}
+	voucher, err := getVoucherByCode(voucherCode)
+	if err != nil {
+		return nil, newPortalError("voucher_invalid")
+	}
+	if voucher.Revoked {
+		return nil, newPortalError("voucher_revoked")
+	}
+	if voucher.AppliedTo != 0 {
+		return nil, newPortalError("voucher_topped_up")
+	}
+	if voucher.IsUsed {
+		return nil, newPortalError("voucher_used")
+	}
+	if voucher.IsReusable {
+		return nil, newPortalError("voucher_shared_topup")
+	}
+	if code := voucher.validityError(time.Now()); code != "" {
+		return nil, newPortalError(code)
+	}
+	if voucher.Duration <= 0 && voucher.DataLimit <= 0 {
+		return nil, newPortalError("voucher_empty")
+	}
+	return voucher, nil
+}
+
+// applyTopUp extends the MAC's session and pushes the new remaining time to
+// NoDogSplash. Devices on a non-shared voucher share its clock, so every one
+// NDS has authenticated is updated. It returns the seconds now remaining.
+func applyTopUp(mac string, minutes, dataMB int, funding *Voucher) (int, error) {
+	v, expiry, err := topUpSession(mac, minutes, dataMB, funding)
+	if err != nil {
+		return 0, err
+	}
+	remaining := 0 // no time limit
+	if !expiry.IsZero() {
+		remaining = int(time.Until(expiry).Seconds())
+	}
+	log.Printf("Topped up voucher '%s' for MAC %s by %d min / %d MB, %ds remaining", v.Code, mac, minutes, dataMB, remaining)
+
+	mac = normalizeMAC(mac)
+	macs := []string{mac}
+	if !v.IsReusable {
+		if clients, err := nds.Clients(); err == nil {
+			for _, c := range clients {
+				other := normalizeMAC(c.MAC)
+				if other != mac && c.State == ndsStateAuthenticated && v.findDevice(other) != nil {
+					macs = append(macs, other)
+				}
+			}
+		}
+	}
+	for _, m := range macs {
+		if err := ndsReauth(m, remaining); err != nil {
+			log.Printf("Failed to update NDS session for %s after top-up: %v", m, err)
+		}
+	}
+	return remaining, nil
+}
+
+func topUpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	voucherCode := r.URL.Query().Get("voucher")
+	clientMAC := callerMAC(r)
+	if clientMAC == "" {
+		writePortalError(w, r, http.StatusBadRequest, newPortalError("mac_required"))
+		return
+	}
+
+	funding, perr := validateTopUpVoucher(voucherCode)
+	if perr != nil {
+		log.Printf("Top-up validation failed for voucher '%s': %s", voucherCode, perr)
+		writePortalError(w, r, http.StatusUnauthorized, perr)
+		return
+	}
+
+	remaining, err := applyTopUp(clientMAC, funding.Duration, funding.DataLimit, funding)
+	if err != nil {
+		log.Printf("Top-up of %s with voucher '%s' failed: %v", clientMAC, voucherCode, err)
+		code := "topup_failed"
+		switch err {
+		case errNoActiveSession:
+			code = "no_active_session"
+		case errSelfTopUp:
+			code = "self_topup"
+		case errTopUpCapped:
+			code = "topup_capped"
+		}
+		writePortalError(w, r, http.StatusBadRequest, newPortalError(code))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "success",
+		"added_minutes":     funding.Duration,
+		"added_data_mb":     funding.DataLimit,
+		"remaining_seconds": remaining,
+	})
+}
+
 func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
@@ -252,6 +528,22 @@ func adminAddHandler(w http.ResponseWriter, r *http.Request) {
 	if v.Name == "" {
 		v.Name = v.Code
 	}
+	if v.MaxDevices < 0 || v.MaxRedemptions < 0 {
+		http.Error(w, `{"error": "Device and redemption limits cannot be negative"}`, http.StatusBadRequest)
+		return
+	}
+	if v.Lifetime < 0 {
+		http.Error(w, `{"error": "Lifetime cannot be negative"}`, http.StatusBadRequest)
+		return
+	}
+	if v.UploadRate < 0 || v.DownloadRate < 0 {
+		http.Error(w, `{"error": "Rate limits cannot be negative"}`, http.StatusBadRequest)
+		return
+	}
+	if errMsg := validateAccessWindows(v.AccessWindows); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
 
 	err := addVoucher(v)
 	if err != nil {
@@ -273,14 +565,416 @@ func adminDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Look up the bound devices first so they can be kicked once the record
+	// is gone; otherwise they stay online until their NDS timer runs out.
+	var macs []string
+	if v, err := getVoucherByID(payload.ID); err == nil {
+		for _, d := range v.Devices {
+			macs = append(macs, d.MAC)
+		}
+	}
+
 	err := deleteVoucher(payload.ID)
 	if err != nil {
 		http.Error(w, `{"error": "Could not delete voucher"}`, http.StatusInternalServerError)
 		return
 	}
+	recordAudit(AuditEntry{VoucherID: payload.ID, Action: "delete", Actor: r.RemoteAddr})
+	disconnectClients(macs)
+	w.Write([]byte(`{"status": "success"}`))
+}
+
+// disconnectClients drops any staged authentication for the MACs and deauths
+// them in NoDogSplash. It returns the MACs that could not be deauthenticated.
+func disconnectClients(macs []string) []string {
+	failed := make([]string, 0)
+	for _, mac := range macs {
+		mac = normalizeMAC(mac)
+		unstageMAC(mac)
+		setKicked(mac, true)
+
+		if err := nds.Deauth(mac); err != nil {
+			log.Printf("Failed to deauth %s: %v", mac, err)
+			failed = append(failed, mac)
+			continue
+		}
+		log.Printf("Deauthenticated %s in NoDogSplash", mac)
+	}
+	return failed
+}
+
+func adminRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	macs, err := revokeVoucher(payload.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Could not revoke voucher"}`, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Revoked voucher %d, disconnecting %d device(s)", payload.ID, len(macs))
+	recordAudit(AuditEntry{VoucherID: payload.ID, Action: "revoke", Actor: r.RemoteAddr})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "success",
+		"disconnected":      len(macs),
+		"disconnect_failed": disconnectClients(macs),
+	})
+}
+
+// adminTopUpHandler extends a device's session either with a voucher code or
+// with time/data granted directly by the admin.
+func adminTopUpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		MAC     string `json:"mac"`
+		Minutes int    `json:"minutes"`
+		DataMB  int    `json:"data_mb"`
+		Voucher string `json:"voucher"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MAC == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var funding *Voucher
+	if payload.Voucher != "" {
+		v, perr := validateTopUpVoucher(payload.Voucher)
+		if perr != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, perr), http.StatusBadRequest)
+			return
+		}
+		funding = v
+		payload.Minutes, payload.DataMB = v.Duration, v.DataLimit
+	} else if payload.Minutes < 0 || payload.DataMB < 0 || payload.Minutes+payload.DataMB == 0 {
+		http.Error(w, `{"error": "Specify positive minutes or data to add"}`, http.StatusBadRequest)
+		return
+	}
+
+	remaining, err := applyTopUp(payload.MAC, payload.Minutes, payload.DataMB, funding)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Could not top up: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if v, _ := findActiveVoucher(payload.MAC); v != nil {
+		recordAudit(AuditEntry{
+			VoucherID: v.ID,
+			Action:    "topup",
+			New:       fmt.Sprintf("+%d min, +%d MB for %s", payload.Minutes, payload.DataMB, normalizeMAC(payload.MAC)),
+			Actor:     r.RemoteAddr,
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "remaining_seconds": remaining})
+}
+
+func adminClientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	clients, err := getConnectedClients()
+	if err != nil {
+		log.Printf("Failed to list connected clients: %v", err)
+		http.Error(w, fmt.Sprintf(`{"error": "Could not list clients: %s"}`, err), http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(clients)
+}
+
+// adminBlockHandler blocks (/admin/block) or unblocks (/admin/unblock) a MAC.
+// It is shorthand for editing the persisted blocked list.
+func adminBlockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		MAC string `json:"mac"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MAC == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	block := r.URL.Path == "/admin/block"
+	var err error
+	if block {
+		err = addListedMAC(macListBlocked, payload.MAC, "")
+	} else {
+		err = removeListedMAC(macListBlocked, payload.MAC)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Set blocked=%v for %s", block, payload.MAC)
+	w.Write([]byte(`{"status": "success"}`))
+}
+
+func adminMACListsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(macListsCache)
+}
+
+// adminMACListEditHandler adds (/admin/macs/add) or removes
+// (/admin/macs/remove) a MAC on the trusted, blocked or allowed list.
+func adminMACListEditHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		List string `json:"list"`
+		MAC  string `json:"mac"`
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MAC == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if r.URL.Path == "/admin/macs/add" {
+		err = addListedMAC(payload.List, payload.MAC, strings.TrimSpace(payload.Note))
+	} else {
+		err = removeListedMAC(payload.List, payload.MAC)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Updated %s MAC list (%s %s)", payload.List, r.URL.Path, payload.MAC)
+	w.Write([]byte(`{"status": "success"}`))
+}
+
+// adminWalledGardenHandler returns the walled-garden list on GET. On POST it
+// validates the new list, renders it into the NoDogSplash config and restarts
+// NDS, keeping the old list if that fails.
+func adminWalledGardenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	walledGardenMutex.Lock()
+	defer walledGardenMutex.Unlock()
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(walledGardenCache)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var entries []WalledGardenEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if errMsg := validateWalledGarden(entries); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
+
+	applied := true
+	if err := applyWalledGarden(entries); err == errNDSConfigMissing {
+		applied = false // dev: keep the list so it applies once deployed
+	} else if err != nil {
+		log.Printf("Failed to apply walled garden: %v", err)
+		http.Error(w, fmt.Sprintf(`{"error": "Could not apply walled garden, previous config restored: %s"}`, strings.ReplaceAll(err.Error(), `"`, `'`)), http.StatusBadGateway)
+		return
+	}
+
+	walledGardenCache = entries
+	if err := saveWalledGarden(); err != nil {
+		http.Error(w, `{"error": "Could not save walled garden"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "applied": applied})
+}
+
+// adminDisconnectHandler kicks a single device regardless of its voucher. The
+// voucher stays valid, so the device can log back in with it; revoke the
+// voucher to stop that.
+func adminDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		MAC string `json:"mac"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MAC == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if failed := disconnectClients([]string{payload.MAC}); len(failed) > 0 {
+		http.Error(w, `{"error": "Could not disconnect device from NoDogSplash"}`, http.StatusBadGateway)
+		return
+	}
 	w.Write([]byte(`{"status": "success"}`))
 }
 
+// validateVoucherUpdate checks an update against the voucher it targets and
+// returns a message describing the first problem found.
+func validateVoucherUpdate(v *Voucher, u *VoucherUpdate) string {
+	if u.Code != nil {
+		code := strings.TrimSpace(*u.Code)
+		u.Code = &code
+		if code != v.Code {
+			if code == "" {
+				return "Voucher code cannot be empty"
+			}
+			if v.IsUsed {
+				return "Voucher code cannot be changed once the voucher has been used"
+			}
+			if _, err := getVoucherByCode(code); err == nil {
+				return "Voucher code already exists"
+			}
+		}
+	}
+	if u.Duration != nil && *u.Duration < 0 {
+		return "Duration cannot be negative"
+	}
+	if u.Price != nil && *u.Price < 0 {
+		return "Price cannot be negative"
+	}
+	if u.DataLimit != nil && *u.DataLimit < 0 {
+		return "Data limit cannot be negative"
+	}
+	if (u.UploadRate != nil && *u.UploadRate < 0) || (u.DownloadRate != nil && *u.DownloadRate < 0) {
+		return "Rate limits cannot be negative"
+	}
+	if (u.MaxDevices != nil && *u.MaxDevices < 0) || (u.MaxRedemptions != nil && *u.MaxRedemptions < 0) {
+		return "Device and redemption limits cannot be negative"
+	}
+	if u.AccessWindows != nil {
+		if errMsg := validateAccessWindows(*u.AccessWindows); errMsg != "" {
+			return errMsg
+		}
+	}
+	if u.Lifetime != nil && *u.Lifetime < 0 {
+		return "Lifetime cannot be negative"
+	}
+	if u.Expiration != nil && *u.Expiration != "" {
+		exp, ok := parseVoucherDeadline(*u.Expiration)
+		if !ok {
+			return "Expiration must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		if !exp.After(time.Now()) {
+			return "Expiration must be in the future"
+		}
+		u.expiration = exp
+	}
+	if u.ActivateBy != nil && *u.ActivateBy != "" {
+		deadline, ok := parseVoucherDeadline(*u.ActivateBy)
+		if !ok {
+			return "Activation deadline must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		if !deadline.After(time.Now()) {
+			return "Activation deadline must be in the future"
+		}
+		u.activateBy = deadline
+	}
+	return ""
+}
+
+// parseVoucherDeadline parses an RFC 3339 timestamp, or a bare date meaning
+// the end of that day in router time.
+func parseVoucherDeadline(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	day, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day.Add(24*time.Hour - time.Second), true
+}
+
+func adminUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var u VoucherUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	v, err := getVoucherByID(u.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Voucher not found"}`, http.StatusNotFound)
+		return
+	}
+	if errMsg := validateVoucherUpdate(v, &u); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := updateVoucher(u, r.RemoteAddr)
+	if err != nil {
+		log.Printf("Error updating voucher %d: %v", u.ID, err)
+		http.Error(w, `{"error": "Could not update voucher"}`, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Updated voucher %d", u.ID)
+	json.NewEncoder(w).Encode(updated)
+}
+
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	voucherID := 0
+	if idParam := r.URL.Query().Get("voucher_id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid voucher_id"}`, http.StatusBadRequest)
+			return
+		}
+		voucherID = id
+	}
+	json.NewEncoder(w).Encode(getAuditLog(voucherID))
+}
+
+// adminSessionsHandler returns the session history, filtered by
+// ?voucher_id= and/or ?mac=.
+func adminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	voucherID := 0
+	if idParam := r.URL.Query().Get("voucher_id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid voucher_id"}`, http.StatusBadRequest)
+			return
+		}
+		voucherID = id
+	}
+	json.NewEncoder(w).Encode(getSessions(voucherID, r.URL.Query().Get("mac")))
+}
+
+func adminUnbindHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		ID  int    `json:"id"`
+		MAC string `json:"mac"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MAC == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := unbindDevice(payload.ID, payload.MAC); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Could not unbind device: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Unbound MAC %s from voucher %d", payload.MAC, payload.ID)
+	recordAudit(AuditEntry{VoucherID: payload.ID, Action: "unbind", Old: normalizeMAC(payload.MAC), Actor: r.RemoteAddr})
+	w.Write([]byte(`{"status": "success"}`))
+}
+
+// adminVoucher is a voucher as the admin UI lists it, with the status and end
+// of access the backend enforces, so the UI never works them out itself.
+type adminVoucher struct {
+	Voucher
+	Status    string     `json:"status"`               // see Voucher.state
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // unset without a time limit
+}
+
 func adminVouchersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vouchers, err := getVouchers()
@@ -288,7 +982,17 @@ func adminVouchersHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(vouchers)
+	now := time.Now()
+	list := make([]adminVoucher, 0, len(vouchers))
+	for _, v := range vouchers {
+		status, ends := v.state(now)
+		av := adminVoucher{Voucher: v, Status: status}
+		if !ends.IsZero() {
+			av.ExpiresAt = &ends
+		}
+		list = append(list, av)
+	}
+	json.NewEncoder(w).Encode(list)
 }
 
 func adminChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
@@ -323,6 +1027,7 @@ func adminGetSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	if _, ok := settings["currency_symbol"]; !ok {
 		settings["currency_symbol"] = "$"
 	}
+	settings["default_language"] = defaultLanguage()
 	json.NewEncoder(w).Encode(settings)
 }
 
@@ -333,8 +1038,16 @@ func adminUpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
 		return
 	}
+	if theme, ok := newSettings["active_theme"]; ok && themePath(theme) == "" {
+		http.Error(w, fmt.Sprintf(`{"error": "Theme '%s' is not installed"}`, strings.ReplaceAll(theme, `"`, `'`)), http.StatusBadRequest)
+		return
+	}
+	if lang, ok := newSettings["default_language"]; ok && !isSupportedLanguage(lang) {
+		http.Error(w, fmt.Sprintf(`{"error": "Language must be one of %s"}`, strings.Join(supportedLanguages, ", ")), http.StatusBadRequest)
+		return
+	}
 	for k, v := range newSettings {
-		if k == "currency_symbol" || k == "active_theme" {
+		if k == "currency_symbol" || k == "active_theme" || k == "default_language" {
 			setSetting(k, v)
 		}
 	}
@@ -349,6 +1062,7 @@ func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 	activeVouchers := 0
 	expiredCount := 0
 	unusedCount := 0
+	redemptions := 0
 
 	salesByMonth := make(map[string]float64)
 	sixMonthsAgo := now.AddDate(0, -6, 0)
@@ -363,15 +1077,14 @@ func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 			month := v.CreatedAt.Format("2006-01")
 			salesByMonth[month] += v.Price
 		}
-		if v.IsUsed {
-			expires := v.StartTime.Add(time.Duration(v.Duration) * time.Minute)
-			if now.After(expires) {
-				expiredCount++
-			} else {
-				activeVouchers++
-			}
-		} else {
+		redemptions += len(v.Redemptions)
+		switch status, _ := v.state(now); status {
+		case "active":
+			activeVouchers++
+		case "unused":
 			unusedCount++
+		default:
+			expiredCount++
 		}
 	}
 
@@ -396,7 +1109,8 @@ func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 	stats := map[string]interface{}{
 		"total_revenue":   totalRevenue,
 		"active_vouchers": activeVouchers,
-		"live_users":      activeVouchers,
+		"live_users":      liveUsers(),
+		"redemptions":     redemptions,
 		"sales_stats":     map[string]interface{}{"labels": salesLabels, "data": salesData},
 		"voucher_status":  map[string]int{"active": activeVouchers, "expired": expiredCount, "unused": unusedCount},
 		"top_plans":       planList,
@@ -405,95 +1119,293 @@ func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// liveUsers counts the clients NoDogSplash has authenticated right now. When
+// NDS is unreachable it falls back to the number of running voucher sessions.
+func liveUsers() int {
+	clients, err := nds.Clients()
+	if err != nil {
+		return len(getActiveSessions())
+	}
+	count := 0
+	for _, c := range clients {
+		if c.State == ndsStateAuthenticated {
+			count++
+		}
+	}
+	return count
+}
+
+// fasHandler is the Forwarding Authentication Service endpoint NDS sends
+// unauthenticated clients to. It verifies the NDS-issued token, records the
+// client's real MAC in a login session and redirects to the portal with only
+// the session id. Clients that still have time left are sent straight back
+// through NDS auth, unless an admin or the customer logged them out: those
+// have to log in again.
+func fasHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := fasParams(r.URL.Query())
+	var client *ndsClientInfo
+	var tok string
+	if err == nil {
+		client, tok, err = verifyFASClient(params)
+	}
+	if err != nil {
+		log.Printf("[fas] Rejected FAS request from %s: %v", remoteIP(r), err)
+		http.Error(w, translate(requestLanguage(r), "invalid_login_link"), http.StatusForbidden)
+		return
+	}
+	if client.IP != remoteIP(r) {
+		log.Printf("[fas] Token for %s (%s) presented from %s", client.MAC, client.IP, remoteIP(r))
+		http.Error(w, translate(requestLanguage(r), "invalid_login_link"), http.StatusForbidden)
+		return
+	}
+	authURL := fasAuthURL(r, params, tok)
+
+	for _, s := range getActiveSessions() {
+		if s.MAC != client.MAC || isKicked(client.MAC) {
+			continue
+		}
+		seconds, _ := s.remaining(time.Now())
+		nonce, err := stageAuth(client.MAC, seconds)
+		if err != nil {
+			log.Printf("[fas] Failed to stage %s: %v", client.MAC, err)
+			break
+		}
+		http.Redirect(w, r, withNonce(authURL, nonce), http.StatusFound)
+		return
+	}
+
+	sid, err := newFASSession(&fasSession{MAC: client.MAC, IP: client.IP, AuthURL: authURL})
+	if err != nil {
+		log.Printf("[fas] Failed to create login session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/?sid="+sid, http.StatusFound)
+}
+
+// binauthStageHandler redeems a voucher for the client behind the request.
+// The client comes from the FAS login session when the portal was reached
+// through /fas; otherwise NDS is asked which MAC has the request's IP, so a
+// browser cannot stage auth for some other device. Only when NDS is not
+// available (dev) is the MAC from the query trusted.
 func binauthStageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	voucherCode := r.URL.Query().Get("voucher")
-	clientMAC := r.URL.Query().Get("mac")
-	clientIP := r.URL.Query().Get("ip")
+	clientMAC, clientIP, authURL, ok := portalClient(w, r)
+	if !ok {
+		return
+	}
 
-	if clientMAC == "" {
-		http.Error(w, `{"error": "Client MAC address is required"}`, http.StatusBadRequest)
+	voucher, perr := validateVoucher(voucherCode, clientMAC)
+	if perr != nil {
+		log.Printf("BinAuth stage validation failed for voucher '%s': %s", voucherCode, perr)
+		writePortalError(w, r, http.StatusUnauthorized, perr)
 		return
 	}
 
-	voucher, errMsg := validateVoucher(voucherCode)
-	if errMsg != "" {
-		log.Printf("BinAuth stage validation failed for voucher '%s': %s", voucherCode, errMsg)
-		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusUnauthorized)
+	expiry, err := useVoucher(voucher.Code, clientIP, clientMAC)
+	if err != nil {
+		log.Printf("Error marking voucher as used: %v", err)
+		writePortalError(w, r, http.StatusInternalServerError, newPortalError("internal_error"))
 		return
 	}
 
-	if !voucher.IsUsed {
-		err := useVoucher(voucher.Code, clientIP, clientMAC)
-		if err != nil {
-			log.Printf("Error marking voucher as used: %v", err)
-			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
-			return
-		}
+	// Returning devices, shared-code devices, topped-up sessions and vouchers
+	// near the end of their validity all have their own expiry, so hand NDS
+	// (and the customer) what is actually left. The expiry comes from the
+	// stored voucher, whose clock useVoucher has just started on first use.
+	durationInSeconds, _ := activeSession{MAC: clientMAC, Expiry: expiry}.remaining(time.Now())
+	setKicked(clientMAC, false)
+	nonce, err := stageAuth(clientMAC, durationInSeconds)
+	if err != nil {
+		log.Printf("Error staging %s: %v", clientMAC, err)
+		writePortalError(w, r, http.StatusInternalServerError, newPortalError("internal_error"))
+		return
 	}
 
-	durationInSeconds := voucher.Duration * 60
-	stagedAuthsMutex.Lock()
-	stagedAuths[clientMAC] = durationInSeconds
-	stagedAuthsMutex.Unlock()
+	resp := map[string]interface{}{"status": "success", "duration": (durationInSeconds + 59) / 60}
+	if authURL != "" {
+		resp["auth_url"] = withNonce(authURL, nonce)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
 
-	time.AfterFunc(30*time.Second, func() {
-		stagedAuthsMutex.Lock()
-		delete(stagedAuths, clientMAC)
-		stagedAuthsMutex.Unlock()
-	})
+// portalClient identifies the client logging in from the portal: its MAC, IP
+// and the NDS auth URL to send it to. On failure it writes the error reply
+// and returns false.
+func portalClient(w http.ResponseWriter, r *http.Request) (mac, ip, authURL string, ok bool) {
+	q := r.URL.Query()
+	mac, ip = q.Get("mac"), q.Get("ip")
+
+	if sid := q.Get("sid"); sid != "" {
+		session := getFASSession(sid)
+		if session == nil {
+			writePortalError(w, r, http.StatusUnauthorized, newPortalError("session_expired"))
+			return "", "", "", false
+		}
+		mac, ip, authURL = session.MAC, session.IP, session.AuthURL
+	} else if client, err := ndsClientByIP(remoteIP(r)); err == nil {
+		if client == nil {
+			writePortalError(w, r, http.StatusUnauthorized, newPortalError("device_not_found"))
+			return "", "", "", false
+		}
+		mac, ip = client.MAC, client.IP
+		authURL = fasAuthURL(r, map[string]string{}, client.Token)
+	} else if err != errNDSUnavailable {
+		log.Printf("Failed to look up client %s in NoDogSplash: %v", remoteIP(r), err)
+		writePortalError(w, r, http.StatusInternalServerError, newPortalError("internal_error"))
+		return "", "", "", false
+	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "duration": voucher.Duration})
+	if mac == "" {
+		writePortalError(w, r, http.StatusBadRequest, newPortalError("mac_required"))
+		return "", "", "", false
+	}
+	return normalizeMAC(mac), ip, authURL, true
 }
 
+// binauthCheckHandler answers binauth.sh's auth_client call for the client,
+// or 401. A staged redemption is consumed with the nonce NDS passed as the
+// BinAuth password; without one, a device whose voucher is still running
+// (e.g. after a reboot) is let back in for the time it has left, unless an
+// admin disconnected it.
+//
+// The reply is "<seconds> <upload_rate> <download_rate> <upload_quota>
+// <download_quota>" with rates in kbit/s, quotas in kB and 0 for unlimited.
+// NoDogSplash reads the first three fields; openNDS also enforces the quota.
 func binauthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	clientMAC := r.URL.Query().Get("mac")
+	if !isLoopback(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	clientMAC := normalizeMAC(r.URL.Query().Get("mac"))
 	if clientMAC == "" {
 		http.Error(w, "MAC address required", http.StatusBadRequest)
 		return
 	}
 
-	stagedAuthsMutex.Lock()
-	duration, ok := stagedAuths[clientMAC]
-	if ok {
-		delete(stagedAuths, clientMAC)
+	if nonce := r.URL.Query().Get("nonce"); nonce != "" {
+		if duration, ok := consumeStagedAuth(nonce, clientMAC); ok {
+			writeBinauthGrant(w, clientMAC, duration)
+			return
+		}
+		log.Printf("[binauth] Unknown, expired or mismatched nonce for %s", clientMAC)
 	}
-	stagedAuthsMutex.Unlock()
 
-	if ok {
-		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintf(w, "%d", duration)
+	if isKicked(clientMAC) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
-
-	vouchers, err := getVouchers()
-	if err == nil {
-		now := time.Now()
-		for _, v := range vouchers {
-			if v.UserMAC == clientMAC && v.IsUsed && v.Duration > 0 && !v.StartTime.IsZero() {
-				expiry := v.StartTime.Add(time.Duration(v.Duration) * time.Minute)
-				if now.Before(expiry) {
-					remaining := int(expiry.Sub(now).Seconds())
-					if remaining > 0 {
-						w.Header().Set("Content-Type", "text/plain")
-						fmt.Fprintf(w, "%d", remaining)
-						return
-					}
-				}
-			}
+	now := time.Now()
+	for _, s := range getActiveSessions() {
+		if s.MAC != clientMAC {
+			continue
+		}
+		if remaining, ok := s.remaining(now); ok {
+			writeBinauthGrant(w, clientMAC, remaining)
+			return
 		}
 	}
 	http.Error(w, "Not authorized", http.StatusUnauthorized)
 }
 
-// activeSession is a still-valid voucher session keyed by client MAC.
+// writeBinauthGrant writes the auth_client reply for mac with the voucher's
+// rate limits. openNDS counts the quota per direction, so the combined data
+// left goes on the download side and the reconciler still enforces the total.
+func writeBinauthGrant(w http.ResponseWriter, mac string, seconds int) {
+	var upRate, downRate int
+	var quotaKB int64
+	if v, d := findActiveVoucher(mac); v != nil {
+		upRate, downRate = v.UploadRate, v.DownloadRate
+		quotaKB = remainingDataKB(v, d)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "%d %d %d 0 %d", seconds, upRate, downRate, quotaKB)
+}
+
+// isLoopback reports whether the request came from the router itself, which
+// is where binauth.sh runs.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// binauthEventHandler receives the BinAuth events NoDogSplash reports for a
+// client (forwarded by binauth.sh) and records session starts and stops.
+func binauthEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	action := q.Get("action")
+	clientMAC := normalizeMAC(q.Get("mac"))
+	if clientMAC == "" {
+		http.Error(w, "MAC address required", http.StatusBadRequest)
+		return
+	}
+
+	// NDS passes bytes incoming (to the client) and outgoing, then the session
+	// start and end as Unix timestamps; any of them may be missing or 0.
+	bytesDown, _ := strconv.ParseInt(q.Get("in"), 10, 64)
+	bytesUp, _ := strconv.ParseInt(q.Get("out"), 10, 64)
+	start, end := time.Now(), time.Now()
+	if sec, err := strconv.ParseInt(q.Get("start"), 10, 64); err == nil && sec > 0 {
+		start = time.Unix(sec, 0)
+	}
+	if sec, err := strconv.ParseInt(q.Get("end"), 10, 64); err == nil && sec > 0 {
+		end = time.Unix(sec, 0)
+	}
+
+	var err error
+	switch action {
+	case "client_auth", "ndsctl_auth":
+		err = startSession(clientMAC, start)
+		log.Printf("[binauth] %s: %s authenticated", action, clientMAC)
+	case "client_deauth", "idle_deauth", "timeout_deauth", "ndsctl_deauth", "shutdown_deauth",
+		"downquota_deauth", "upquota_deauth": // the quota events come from openNDS
+		err = endSession(clientMAC, action, start, end, bytesDown, bytesUp)
+		log.Printf("[binauth] %s: %s deauthenticated (%d bytes down, %d bytes up)", action, clientMAC, bytesDown, bytesUp)
+	default:
+		http.Error(w, "Unknown BinAuth action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[binauth] Failed to record %s for %s: %v", action, clientMAC, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+// activeSession is a still-valid voucher session keyed by client MAC. Expiry
+// is zero for sessions without a time limit, e.g. data-only vouchers.
 type activeSession struct {
 	MAC    string
 	Expiry time.Time
 }
 
-// getActiveSessions scans the vouchers for used, time-limited sessions that have
-// not yet expired and returns one entry per MAC with its absolute expiry time.
+// remaining returns the seconds left in the session at now, and false once it
+// has ended. Sessions without a time limit have 0 left, which is also what
+// tells NDS not to time the client out.
+func (s activeSession) remaining(now time.Time) (int, bool) {
+	if s.Expiry.IsZero() {
+		return 0, true
+	}
+	seconds := int(s.Expiry.Sub(now).Seconds())
+	return seconds, seconds > 0
+}
+
+// getActiveSessions scans the vouchers for used sessions that have not yet
+// expired or used up their data, and returns one entry per bound MAC with its
+// absolute expiry time (zero when it has no time limit). Running free trials
+// are included. Outside a voucher's access windows, or while the site schedule
+// has the portal closed, there is no active session.
 func getActiveSessions() []activeSession {
 	vouchers, err := getVouchers()
 	if err != nil {
@@ -502,89 +1414,73 @@ func getActiveSessions() []activeSession {
 	}
 	now := time.Now()
 	sessions := make([]activeSession, 0)
+	if portalClosed(now) {
+		return sessions
+	}
 	for _, v := range vouchers {
-		if v.IsUsed && v.UserMAC != "" && v.Duration > 0 && !v.StartTime.IsZero() {
-			expiry := v.StartTime.Add(time.Duration(v.Duration) * time.Minute)
-			if now.Before(expiry) {
-				sessions = append(sessions, activeSession{MAC: v.UserMAC, Expiry: expiry})
-			}
+		if !v.IsUsed || v.Revoked || !v.inAccessHours(now) {
+			continue
 		}
-	}
-	return sessions
-}
-
-func restageActiveUsers() {
-	now := time.Now()
-	count := 0
-	for _, s := range getActiveSessions() {
-		remaining := int(s.Expiry.Sub(now).Seconds())
-		if remaining > 0 {
-			stagedAuthsMutex.Lock()
-			stagedAuths[s.MAC] = remaining
-			stagedAuthsMutex.Unlock()
-			count++
+		for i := range v.Devices {
+			d := &v.Devices[i]
+			if v.sessionRunning(d, now) && !dataLimitReached(&v, d, 0) {
+				sessions = append(sessions, activeSession{MAC: d.MAC, Expiry: v.sessionExpiry(d)})
+			}
 		}
 	}
-	log.Printf("[restageActiveUsers] Re-staged %d active users for NoDogSplash.", count)
+	return append(sessions, activeTrials()...)
 }
 
 // reauthSessionsViaNDS restores still-valid sessions into NoDogSplash after a
 // reboot. NDS keeps its authenticated-client table in volatile firewall state,
 // so a reboot wipes it and every device would otherwise be intercepted by the
-// splash page once. restageActiveUsers() only makes that re-auth voucher-free;
-// this proactively re-authenticates devices in NDS so no splash appears at all.
+// splash page once. binauth-check already lets such devices back in without a
+// voucher; this proactively re-authenticates them so no splash appears at all.
 //
 // A device can only be authenticated once NDS knows about it (i.e. after it has
 // reconnected and sent traffic). Right after boot most devices haven't
 // re-associated yet, so we retry on an interval, dropping each MAC as soon as
 // `ndsctl auth` succeeds, until every session is restored or the window closes.
 //
-// It no-ops in dev where `ndsctl` is not installed.
+// It no-ops in dev where NoDogSplash is not available.
 func reauthSessionsViaNDS() {
-	ndsctl, err := exec.LookPath("ndsctl")
-	if err != nil {
+	if _, err := nds.Status(); err == errNDSUnavailable {
 		return // not on the router / NoDogSplash not installed
 	}
 
 	// Collect the sessions to restore once; expiry is absolute so the granted
 	// duration shrinks correctly as we retry over the window.
-	pending := make(map[string]time.Time)
+	pending := make(map[string]activeSession)
+	cacheMutex.RLock()
 	for _, s := range getActiveSessions() {
-		pending[s.MAC] = s.Expiry
+		pending[s.MAC] = s
 	}
+	cacheMutex.RUnlock()
 	if len(pending) == 0 {
 		return
 	}
 	log.Printf("[reauthSessionsViaNDS] Attempting to restore %d session(s) into NoDogSplash after boot.", len(pending))
 
-	const (
-		retryInterval = 15 * time.Second
-		window        = 3 * time.Minute
-	)
-	deadline := time.Now().Add(window)
-	for {
+	retryNDS(func() bool {
 		now := time.Now()
-		for mac, expiry := range pending {
-			remaining := int(expiry.Sub(now).Seconds())
-			if remaining <= 0 {
+		for mac, s := range pending {
+			remaining, ok := s.remaining(now)
+			if !ok {
 				delete(pending, mac) // session expired while we were waiting
 				continue
 			}
 			// `ndsctl auth <mac> <seconds>` fails if the client isn't known to
 			// NDS yet; that's expected before the device reconnects, so we just
 			// retry on the next tick.
-			if out, err := exec.Command(ndsctl, "auth", mac, strconv.Itoa(remaining)).CombinedOutput(); err != nil {
-				log.Printf("[reauthSessionsViaNDS] %s not ready yet: %v (%s)", mac, err, string(out))
+			if err := nds.Auth(mac, remaining); err != nil {
+				log.Printf("[reauthSessionsViaNDS] %s not ready yet: %v", mac, err)
 			} else {
 				log.Printf("[reauthSessionsViaNDS] Restored %s in NDS for %ds.", mac, remaining)
 				delete(pending, mac)
 			}
 		}
-		if len(pending) == 0 || time.Now().After(deadline) {
-			break
-		}
-		time.Sleep(retryInterval)
-	}
+		return len(pending) == 0
+	})
 	if len(pending) > 0 {
 		log.Printf("[reauthSessionsViaNDS] Gave up with %d session(s) still pending (devices offline); they will re-auth via the portal when they reconnect.", len(pending))
 	} else {
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNDSUnavailable is returned when ndsctl is not installed, i.e. in dev.
+var errNDSUnavailable = errors.New("NoDogSplash (ndsctl) is not available")
+
+// ndsController is everything the backend asks of NoDogSplash. All access to
+// ndsctl goes through it so the session logic can run against fakeNDS off the
+// router.
+type ndsController interface {
+	Auth(mac string, seconds int) error
+	Deauth(mac string) error
+	Clients() ([]ndsClientInfo, error)
+	Block(mac string) error
+	Unblock(mac string) error
+	Trust(mac string) error
+	Untrust(mac string) error
+	Allow(mac string) error
+	Unallow(mac string) error
+	Status() (string, error)
+}
+
+// nds is the controller used by the handlers, reconciler and restore logic.
+var nds = newNDSController()
+
+// portalDaemon identifies the captive-portal daemon behind ndsctl. NoDogSplash
+// and its successor openNDS share ndsctl and BinAuth but differ in config
+// name, auth directory and some argument units.
+type portalDaemon string
+
+const (
+	daemonNoDogSplash portalDaemon = "nodogsplash"
+	daemonOpenNDS     portalDaemon = "opennds"
+)
+
+// daemon is the portal daemon installed on this router, NoDogSplash unless
+// openNDS is found.
+var daemon = detectPortalDaemon()
+
+func detectPortalDaemon() portalDaemon {
+	if _, err := os.Stat("/etc/init.d/opennds"); err == nil {
+		return daemonOpenNDS
+	}
+	return daemonNoDogSplash
+}
+
+// configPath is the daemon's UCI config file.
+func (d portalDaemon) configPath() string { return "/etc/config/" + string(d) }
+
+// initScript restarts the daemon.
+func (d portalDaemon) initScript() string { return "/etc/init.d/" + string(d) }
+
+// uciOption names an option in the daemon's main UCI section.
+func (d portalDaemon) uciOption(name string) string {
+	return fmt.Sprintf("%s.@%s[0].%s", d, d, name)
+}
+
+// authDir is the path on the gateway that completes a client's login.
+func (d portalDaemon) authDir() string { return string(d) + "_auth" }
+
+// newNDSController returns the ndsctl-backed controller on the router. In dev
+// it returns one that reports errNDSUnavailable, or an in-process fake when
+// VOUCHER_FAKE_NDS=1 so NDS-facing features can be exercised locally.
+func newNDSController() ndsController {
+	if path, err := exec.LookPath("ndsctl"); err == nil {
+		return &execNDS{path: path, daemon: detectPortalDaemon()}
+	}
+	if os.Getenv("VOUCHER_FAKE_NDS") == "1" {
+		// Seed a client for the local browser so the portal flow works,
+		// e.g. through /fas?tok=020000000001.
+		f := newFakeNDS()
+		f.AddClient("02:00:00:00:00:01", "127.0.0.1")
+		log.Printf("ndsctl not found; using in-process fake NoDogSplash with a local client 02:00:00:00:00:01")
+		return f
+	}
+	return unavailableNDS{}
+}
+
+// ndsClientInfo is a client as reported by `ndsctl json`. Byte counters are in
+// kilobytes, timestamps in Unix seconds. openNDS reports the numbers as JSON
+// strings, so decoding goes through UnmarshalJSON.
+type ndsClientInfo struct {
+	ID         int    `json:"id"`
+	IP         string `json:"ip"`
+	MAC        string `json:"mac"`
+	Added      int64  `json:"added"`
+	Active     int64  `json:"active"`
+	Duration   int64  `json:"duration"`
+	Token      string `json:"token"`
+	State      string `json:"state"`
+	Downloaded int64  `json:"downloaded"`
+	Uploaded   int64  `json:"uploaded"`
+}
+
+// UnmarshalJSON accepts the numeric fields as numbers or numeric strings.
+func (c *ndsClientInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID         json.Number `json:"id"`
+		IP         string      `json:"ip"`
+		MAC        string      `json:"mac"`
+		Added      json.Number `json:"added"`
+		Active     json.Number `json:"active"`
+		Duration   json.Number `json:"duration"`
+		Token      string      `json:"token"`
+		State      string      `json:"state"`
+		Downloaded json.Number `json:"downloaded"`
+		Uploaded   json.Number `json:"uploaded"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	num := func(n json.Number) int64 {
+		if v, err := n.Int64(); err == nil {
+			return v
+		}
+		v, _ := n.Float64()
+		return int64(v)
+	}
+	*c = ndsClientInfo{
+		ID:         int(num(raw.ID)),
+		IP:         raw.IP,
+		MAC:        raw.MAC,
+		Added:      num(raw.Added),
+		Active:     num(raw.Active),
+		Duration:   num(raw.Duration),
+		Token:      raw.Token,
+		State:      raw.State,
+		Downloaded: num(raw.Downloaded),
+		Uploaded:   num(raw.Uploaded),
+	}
+	return nil
+}
+
+// NoDogSplash client states as reported in `ndsctl json`.
+const (
+	ndsStatePreauthenticated = "Preauthenticated"
+	ndsStateAuthenticated    = "Authenticated"
+	ndsStateBlocked          = "Blocked"
+	ndsStateTrusted          = "Trusted"
+)
+
+// ndsReauth replaces an authenticated client's session with a new timeout.
+// NoDogSplash will not re-auth a client that is already authenticated, so it
+// is deauthed first; a failing deauth (client not authenticated) is ignored.
+func ndsReauth(mac string, seconds int) error {
+	nds.Deauth(mac)
+	return nds.Auth(mac, seconds)
+}
+
+// NoDogSplash may start after the backend and only learns about clients as
+// they reconnect, so startup work against it is retried for a while.
+const (
+	ndsRetryInterval = 15 * time.Second
+	ndsRetryWindow   = 3 * time.Minute
+)
+
+// retryNDS calls try every ndsRetryInterval until it reports done or
+// ndsRetryWindow has passed, and returns whether it finished.
+func retryNDS(try func() bool) bool {
+	deadline := time.Now().Add(ndsRetryWindow)
+	for !try() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(ndsRetryInterval)
+	}
+	return true
+}
+
+// execNDS drives NoDogSplash by shelling out to ndsctl.
+type execNDS struct {
+	path   string
+	daemon portalDaemon
+}
+
+// run executes ndsctl with the given arguments and returns its output.
+func (n *execNDS) run(args ...string) (string, error) {
+	out, err := exec.Command(n.path, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("ndsctl %s: %v (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func (n *execNDS) Auth(mac string, seconds int) error {
+	timeout := seconds
+	if n.daemon == daemonOpenNDS {
+		timeout = (seconds + 59) / 60 // openNDS takes the session timeout in minutes
+	}
+	_, err := n.run("auth", mac, strconv.Itoa(timeout))
+	return err
+}
+
+func (n *execNDS) Deauth(mac string) error {
+	_, err := n.run("deauth", mac)
+	return err
+}
+
+func (n *execNDS) Clients() ([]ndsClientInfo, error) {
+	out, err := n.run("json")
+	if err != nil {
+		return nil, err
+	}
+	return parseNDSClients([]byte(out))
+}
+
+func (n *execNDS) Block(mac string) error {
+	_, err := n.run("block", mac)
+	return err
+}
+
+func (n *execNDS) Unblock(mac string) error {
+	_, err := n.run("unblock", mac)
+	return err
+}
+
+func (n *execNDS) Trust(mac string) error {
+	_, err := n.run("trust", mac)
+	return err
+}
+
+func (n *execNDS) Untrust(mac string) error {
+	_, err := n.run("untrust", mac)
+	return err
+}
+
+func (n *execNDS) Allow(mac string) error {
+	_, err := n.run("allow", mac)
+	return err
+}
+
+func (n *execNDS) Unallow(mac string) error {
+	_, err := n.run("unallow", mac)
+	return err
+}
+
+func (n *execNDS) Status() (string, error) {
+	return n.run("status")
+}
+
+// parseNDSClients decodes `ndsctl json` output, whose clients are keyed by MAC.
+func parseNDSClients(data []byte) ([]ndsClientInfo, error) {
+	var parsed struct {
+		Clients map[string]ndsClientInfo `json:"clients"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing ndsctl json: %v", err)
+	}
+	clients := make([]ndsClientInfo, 0, len(parsed.Clients))
+	for mac, c := range parsed.Clients {
+		if c.MAC == "" {
+			c.MAC = mac
+		}
+		c.MAC = normalizeMAC(c.MAC)
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// unavailableNDS is used when NoDogSplash is not installed.
+type unavailableNDS struct{}
+
+func (unavailableNDS) Auth(string, int) error            { return errNDSUnavailable }
+func (unavailableNDS) Deauth(string) error               { return errNDSUnavailable }
+func (unavailableNDS) Clients() ([]ndsClientInfo, error) { return nil, errNDSUnavailable }
+func (unavailableNDS) Block(string) error                { return errNDSUnavailable }
+func (unavailableNDS) Unblock(string) error              { return errNDSUnavailable }
+func (unavailableNDS) Trust(string) error                { return errNDSUnavailable }
+func (unavailableNDS) Untrust(string) error              { return errNDSUnavailable }
+func (unavailableNDS) Allow(string) error                { return errNDSUnavailable }
+func (unavailableNDS) Unallow(string) error              { return errNDSUnavailable }
+func (unavailableNDS) Status() (string, error)           { return "", errNDSUnavailable }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeNDS is an in-process NoDogSplash that keeps a client table and records
+// every call made to it, in the same "verb mac [args]" form as ndsctl.
+type fakeNDS struct {
+	mu      sync.Mutex
+	clients map[string]*ndsClientInfo
+	calls   []string
+}
+
+func newFakeNDS() *fakeNDS {
+	return &fakeNDS{clients: make(map[string]*ndsClientInfo)}
+}
+
+// AddClient makes a client known to the fake, as if it had connected and
+// been intercepted by the splash page. Its token is the MAC without colons.
+func (f *fakeNDS) AddClient(mac, ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mac = normalizeMAC(mac)
+	token := strings.ReplaceAll(mac, ":", "")
+	f.clients[mac] = &ndsClientInfo{ID: len(f.clients) + 1, MAC: mac, IP: ip, Token: token, State: ndsStatePreauthenticated}
+}
+
+// Calls returns the recorded calls in order.
+func (f *fakeNDS) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeNDS) record(call ...interface{}) {
+	f.calls = append(f.calls, strings.TrimSpace(fmt.Sprintln(call...)))
+}
+
+// setState changes a known client's state, failing like ndsctl does for
+// clients it has never seen.
+func (f *fakeNDS) setState(mac, state string) error {
+	c, ok := f.clients[normalizeMAC(mac)]
+	if !ok {
+		return fmt.Errorf("client %s not found", mac)
+	}
+	c.State = state
+	return nil
+}
+
+func (f *fakeNDS) Auth(mac string, seconds int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("auth", mac, seconds)
+	c, ok := f.clients[normalizeMAC(mac)]
+	if !ok {
+		return fmt.Errorf("client %s not found", mac)
+	}
+	if c.State == ndsStateAuthenticated {
+		return fmt.Errorf("client %s already authenticated", mac)
+	}
+	c.State = ndsStateAuthenticated
+	c.Duration = int64(seconds)
+	return nil
+}
+
+func (f *fakeNDS) Deauth(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("deauth", mac)
+	return f.setState(mac, ndsStatePreauthenticated)
+}
+
+func (f *fakeNDS) Clients() ([]ndsClientInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clients := make([]ndsClientInfo, 0, len(f.clients))
+	for _, c := range f.clients {
+		clients = append(clients, *c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+func (f *fakeNDS) Block(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("block", mac)
+	f.setState(mac, ndsStateBlocked)
+	return nil
+}
+
+func (f *fakeNDS) Unblock(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("unblock", mac)
+	f.setState(mac, ndsStatePreauthenticated)
+	return nil
+}
+
+func (f *fakeNDS) Trust(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("trust", mac)
+	f.setState(mac, ndsStateTrusted)
+	return nil
+}
+
+func (f *fakeNDS) Untrust(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("untrust", mac)
+	f.setState(mac, ndsStatePreauthenticated)
+	return nil
+}
+
+func (f *fakeNDS) Allow(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("allow", mac)
+	return nil
+}
+
+func (f *fakeNDS) Unallow(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("unallow", mac)
+	return nil
+}
+
+func (f *fakeNDS) Status() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fmt.Sprintf("fake NoDogSplash: %d client(s)\n", len(f.clients)), nil
+}
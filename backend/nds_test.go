@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReauthSessionsRestoresValidSessions(t *testing.T) {
+	expired := runningVoucher(3, "02:00:00:00:00:03", 60)
+	expired.StartTime = time.Now().Add(-2 * time.Hour)
+	expired.Devices[0].BoundAt = expired.StartTime
+	f := useFakeNDS(t, runningVoucher(1, testMAC, 60), runningVoucher(2, otherMAC, 0), expired)
+	f.AddClient(testMAC, "10.0.0.2")
+	f.AddClient(otherMAC, "10.0.0.3")
+	f.AddClient("02:00:00:00:00:03", "10.0.0.4")
+
+	reauthSessionsViaNDS()
+
+	for _, mac := range []string{testMAC, otherMAC} {
+		if s := clientState(t, f, mac); s != ndsStateAuthenticated {
+			t.Errorf("%s state = %s, want %s", mac, s, ndsStateAuthenticated)
+		}
+	}
+	if !hasCall(f, "auth "+otherMAC+" 0") {
+		t.Errorf("calls = %v, want %s restored without a timeout", f.Calls(), otherMAC)
+	}
+	if hasCall(f, "auth 02:00:00:00:00:03") {
+		t.Errorf("calls = %v, want the expired session left out", f.Calls())
+	}
+}
+
+func TestNDSReauthReplacesTimeout(t *testing.T) {
+	f := useFakeNDS(t)
+	f.AddClient(testMAC, "10.0.0.2")
+	f.Auth(testMAC, 60)
+
+	if err := ndsReauth(testMAC, 600); err != nil {
+		t.Fatalf("ndsReauth: %v", err)
+	}
+	clients, _ := f.Clients()
+	if clients[0].State != ndsStateAuthenticated || clients[0].Duration != 600 {
+		t.Errorf("client = %+v, want authenticated for 600s", clients[0])
+	}
+}
+
+func TestFakeNDSRejectsUnknownClients(t *testing.T) {
+	f := newFakeNDS()
+	if err := f.Auth(testMAC, 60); err == nil {
+		t.Error("Auth of an unknown client succeeded, want an error like ndsctl")
+	}
+	if calls := f.Calls(); len(calls) != 1 || calls[0] != "auth "+testMAC+" 60" {
+		t.Errorf("calls = %v, want the failed auth recorded", calls)
+	}
+}
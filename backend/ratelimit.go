@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each client IP a fixed number of requests per window.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: make(map[string]*rateWindow)}
+}
+
+// allow records a request from ip and reports whether it is within the limit.
+func (l *rateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.windows[ip]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Drop finished windows now and then so the map stays small.
+		if len(l.windows) > 1000 {
+			for k, old := range l.windows {
+				if now.Sub(old.start) >= l.window {
+					delete(l.windows, k)
+				}
+			}
+		}
+		l.windows[ip] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	w.count++
+	return w.count <= l.limit
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// reconcileInterval is how often the backend's view of active sessions is
+// pushed onto NoDogSplash.
+const reconcileInterval = time.Minute
+
+// kickedClients holds MACs an admin disconnected or that logged themselves
+// out. Neither the reconciler nor /fas lets them back in, even with a valid
+// voucher, until they log in again with a voucher or trial.
+var kickedClients = make(map[string]bool)
+var kickedClientsMutex = &sync.Mutex{}
+
+func setKicked(mac string, kicked bool) {
+	kickedClientsMutex.Lock()
+	defer kickedClientsMutex.Unlock()
+	if kicked {
+		kickedClients[mac] = true
+	} else {
+		delete(kickedClients, mac)
+	}
+}
+
+func isKicked(mac string) bool {
+	kickedClientsMutex.Lock()
+	defer kickedClientsMutex.Unlock()
+	return kickedClients[mac]
+}
+
+// runSessionReconciler periodically reconciles NoDogSplash with the vouchers.
+// NDS only enforces the timer it was given at auth time, so without this a
+// revoked, shortened or deleted voucher (or a jump in the router clock) would
+// leave the two disagreeing until the client next reconnects.
+func runSessionReconciler() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reconcileSessions()
+	}
+}
+
+// reconcileSessions deauths clients NDS has authenticated without a valid
+// voucher, including when a voucher's access window or the site's opening
+// hours close, and authenticates known clients that hold one but are not
+// authenticated. Every correction is logged.
+func reconcileSessions() {
+	clients, err := nds.Clients()
+	if err != nil {
+		if err != errNDSUnavailable {
+			log.Printf("[reconcileSessions] Failed to list NDS clients: %v", err)
+		}
+		return
+	}
+
+	for _, f := range planReconcile(clients) {
+		c := f.client
+		if f.deauth {
+			if err := nds.Deauth(c.MAC); err != nil {
+				log.Printf("[reconcileSessions] Failed to deauth %s (%s), %s: %v", c.MAC, c.IP, f.reason, err)
+				continue
+			}
+			log.Printf("[reconcileSessions] Deauthed %s (%s): %s.", c.MAC, c.IP, f.reason)
+			continue
+		}
+		if err := nds.Auth(c.MAC, f.seconds); err != nil {
+			log.Printf("[reconcileSessions] Failed to re-auth %s (%s): %v", c.MAC, c.IP, err)
+			continue
+		}
+		log.Printf("[reconcileSessions] Re-authed %s (%s) for %ds: voucher still valid.", c.MAC, c.IP, f.seconds)
+	}
+}
+
+// ndsFix is a correction the reconciler makes to one NDS client: a deauth
+// for reason, or else an auth for seconds.
+type ndsFix struct {
+	client  ndsClientInfo
+	deauth  bool
+	reason  string
+	seconds int
+}
+
+// planReconcile decides the corrections for the NDS clients. It reads the
+// vouchers and sessions under cacheMutex, so handlers changing them at the
+// same time are seen either before or after, and releases it before any
+// ndsctl call.
+func planReconcile(clients []ndsClientInfo) []ndsFix {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
+	// Keep the longest session per MAC; one without a time limit beats all.
+	active := make(map[string]activeSession)
+	for _, s := range getActiveSessions() {
+		cur, seen := active[s.MAC]
+		if !seen || (!cur.Expiry.IsZero() && (s.Expiry.IsZero() || s.Expiry.After(cur.Expiry))) {
+			active[s.MAC] = s
+		}
+	}
+
+	now := time.Now()
+	fixes := make([]ndsFix, 0)
+	for _, c := range clients {
+		session, valid := active[c.MAC]
+		// NDS counts in kB; the running session is not in the history yet.
+		if valid && c.State == ndsStateAuthenticated {
+			if v, d := findActiveVoucher(c.MAC); v != nil && dataLimitReached(v, d, (c.Downloaded+c.Uploaded)*bytesPerNDSKB) {
+				log.Printf("[reconcileSessions] %s (%s) has used up the data on voucher '%s'.", c.MAC, c.IP, v.Code)
+				valid = false
+			}
+		}
+		switch {
+		case c.State == ndsStateAuthenticated && !valid:
+			if isStaged(c.MAC) {
+				continue // auth in flight; the voucher binding lands first
+			}
+			reason := "no valid voucher"
+			if portalClosed(now) {
+				reason = "the portal is closed"
+			} else if v, _ := findActiveVoucher(c.MAC); v != nil && !v.inAccessHours(now) {
+				reason = "outside the voucher's access hours"
+			}
+			fixes = append(fixes, ndsFix{client: c, deauth: true, reason: reason})
+		case c.State == ndsStatePreauthenticated && valid && !isKicked(c.MAC):
+			if remaining, ok := session.remaining(now); ok {
+				fixes = append(fixes, ndsFix{client: c, seconds: remaining})
+			}
+		}
+	}
+	return fixes
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testMAC  = "02:00:00:00:00:01"
+	otherMAC = "02:00:00:00:00:02"
+)
+
+// useFakeNDS points the stores at an empty data directory holding the given
+// vouchers and swaps in a fresh fakeNDS, restoring both when the test ends.
+func useFakeNDS(t *testing.T, vouchers ...Voucher) *fakeNDS {
+	t.Helper()
+	dir := t.TempDir()
+	oldDir, oldDB, oldSettings, oldNDS := dataDir, voucherDBPath, settingsPath, nds
+	dataDir = dir
+	voucherDBPath = filepath.Join(dir, "voucher.json")
+	settingsPath = filepath.Join(dir, "settings.json")
+	if err := setupDatabase(); err != nil {
+		t.Fatalf("setupDatabase: %v", err)
+	}
+	vouchersCache = vouchers
+
+	f := newFakeNDS()
+	nds = f
+	t.Cleanup(func() {
+		dataDir, voucherDBPath, settingsPath, nds = oldDir, oldDB, oldSettings, oldNDS
+		kickedClients = make(map[string]bool)
+		stagedAuths = make(map[string]*stagedAuth)
+	})
+	return f
+}
+
+// runningVoucher returns a voucher first used by mac a minute ago.
+func runningVoucher(id int, mac string, minutes int) Voucher {
+	start := time.Now().Add(-time.Minute)
+	return Voucher{
+		ID: id, Code: fmt.Sprintf("CODE%d", id), Duration: minutes,
+		IsUsed: true, StartTime: start, UserMAC: mac,
+		Devices: []VoucherDevice{{MAC: mac, BoundAt: start}},
+	}
+}
+
+// clientState returns the fake's current state for mac.
+func clientState(t *testing.T, f *fakeNDS, mac string) string {
+	t.Helper()
+	clients, _ := f.Clients()
+	for _, c := range clients {
+		if c.MAC == mac {
+			return c.State
+		}
+	}
+	t.Fatalf("client %s not known to the fake", mac)
+	return ""
+}
+
+// hasCall reports whether a call starting with prefix was made.
+func hasCall(f *fakeNDS, prefix string) bool {
+	for _, c := range f.Calls() {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconcileDeauthsClientWithoutVoucher(t *testing.T) {
+	f := useFakeNDS(t)
+	f.AddClient(testMAC, "10.0.0.2")
+	f.Auth(testMAC, 600)
+
+	reconcileSessions()
+
+	if !hasCall(f, "deauth "+testMAC) {
+		t.Errorf("calls = %v, want a deauth of %s", f.Calls(), testMAC)
+	}
+	if s := clientState(t, f, testMAC); s != ndsStatePreauthenticated {
+		t.Errorf("state = %s, want %s", s, ndsStatePreauthenticated)
+	}
+}
+
+func TestReconcileReauthsPreauthenticatedClientWithVoucher(t *testing.T) {
+	f := useFakeNDS(t, runningVoucher(1, testMAC, 60))
+	f.AddClient(testMAC, "10.0.0.2")
+
+	reconcileSessions()
+
+	if s := clientState(t, f, testMAC); s != ndsStateAuthenticated {
+		t.Fatalf("state = %s, want %s", s, ndsStateAuthenticated)
+	}
+	clients, _ := f.Clients()
+	// One of the voucher's 60 minutes has gone.
+	if d := clients[0].Duration; d < 58*60 || d > 59*60 {
+		t.Errorf("auth duration = %ds, want about 59 minutes", d)
+	}
+}
+
+func TestReconcileLeavesStagedAndKickedClientsAlone(t *testing.T) {
+	f := useFakeNDS(t, runningVoucher(1, otherMAC, 60))
+	f.AddClient(testMAC, "10.0.0.2")
+	f.AddClient(otherMAC, "10.0.0.3")
+	f.Auth(testMAC, 600)
+
+	// testMAC is authenticated without a voucher, but its login is in flight.
+	if _, err := stageAuth(testMAC, 600); err != nil {
+		t.Fatal(err)
+	}
+	// otherMAC holds a valid voucher but an admin disconnected it.
+	setKicked(otherMAC, true)
+
+	before := len(f.Calls())
+	reconcileSessions()
+
+	if calls := f.Calls()[before:]; len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+	if s := clientState(t, f, otherMAC); s != ndsStatePreauthenticated {
+		t.Errorf("kicked client state = %s, want %s", s, ndsStatePreauthenticated)
+	}
+}
+
+func TestReconcileDeauthsClientOverDataLimit(t *testing.T) {
+	v := runningVoucher(1, testMAC, 60)
+	v.DataLimit = 1 // MB
+	f := useFakeNDS(t, v)
+	f.AddClient(testMAC, "10.0.0.2")
+	f.Auth(testMAC, 3600)
+
+	// Still under the limit: left alone.
+	f.clients[testMAC].Downloaded = 500
+	reconcileSessions()
+	if hasCall(f, "deauth") {
+		t.Fatalf("calls = %v, want no deauth under the data limit", f.Calls())
+	}
+
+	// The live NDS counters (kB) push it over.
+	f.clients[testMAC].Downloaded = 1100
+	reconcileSessions()
+	if !hasCall(f, "deauth "+testMAC) {
+		t.Errorf("calls = %v, want a deauth once the data is used up", f.Calls())
+	}
+}
+
+func TestReconcileKeepsVoucherWithoutDuration(t *testing.T) {
+	v := runningVoucher(1, testMAC, 0)
+	v.DataLimit = 100
+	f := useFakeNDS(t, v, runningVoucher(2, otherMAC, 0))
+	f.AddClient(testMAC, "10.0.0.2")
+	f.AddClient(otherMAC, "10.0.0.3")
+	f.Auth(testMAC, 0)
+
+	reconcileSessions()
+
+	if hasCall(f, "deauth") {
+		t.Errorf("calls = %v, want no deauth for vouchers without a duration", f.Calls())
+	}
+	// The preauthenticated one is let in with no timeout.
+	if !hasCall(f, "auth "+otherMAC+" 0") {
+		t.Errorf("calls = %v, want %s authenticated without a timeout", f.Calls(), otherMAC)
+	}
+}
+
+func TestReconcileCapsVoucherWithoutDurationAtExpiration(t *testing.T) {
+	v := runningVoucher(1, testMAC, 0)
+	v.Expiration = time.Now().Add(time.Hour)
+	f := useFakeNDS(t, v)
+	f.AddClient(testMAC, "10.0.0.2")
+
+	reconcileSessions()
+
+	clients, _ := f.Clients()
+	if d := clients[0].Duration; d < 59*60 || d > 60*60 {
+		t.Errorf("auth duration = %ds, want about an hour, not unlimited", d)
+	}
+}
+
+// Run with -race: the reconciler goroutine reads the caches handlers write.
+func TestReconcileRunsAlongsideVoucherChanges(t *testing.T) {
+	f := useFakeNDS(t, runningVoucher(1, testMAC, 60), Voucher{ID: 2, Code: "SHARED", Duration: 60, IsReusable: true, MaxDevices: 50})
+	f.AddClient(testMAC, "10.0.0.2")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			reconcileSessions()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		mac := fmt.Sprintf("02:00:00:00:01:%02x", i)
+		if _, err := useVoucher("SHARED", "10.0.1.1", mac); err != nil {
+			t.Fatalf("useVoucher: %v", err)
+		}
+		startSession(mac, time.Now())
+	}
+	<-done
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAccessWindows caps the windows on a voucher or the site schedule.
+const maxAccessWindows = 14
+
+// AccessWindow is a span of the week during which access is allowed: Start to
+// End ("HH:MM", router local time) on each of Days. An End at or before Start
+// runs past midnight into the next day, so "22:00"-"06:00" on Friday covers
+// Friday night. No days means every day.
+type AccessWindow struct {
+	Days  []time.Weekday `json:"days,omitempty"` // 0 = Sunday
+	Start string         `json:"start"`
+	End   string         `json:"end"` // "24:00" for the end of the day
+}
+
+// SiteSchedule is when the whole portal is open. No windows means always
+// open; outside them nobody is let in and running sessions are ended.
+type SiteSchedule struct {
+	Windows       []AccessWindow `json:"windows"`
+	ClosedMessage string         `json:"closed_message"` // shown on the portal while closed
+}
+
+var siteSchedule SiteSchedule
+
+func loadSchedule() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	siteSchedule = SiteSchedule{}
+	return readJSONFile(dataPath("schedule.json"), &siteSchedule)
+}
+
+func saveSchedule() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return writeJSONFile(dataPath("schedule.json"), siteSchedule)
+}
+
+// parseClock turns "HH:MM" into minutes after midnight, accepting "24:00".
+func parseClock(s string) (int, bool) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok || len(h) != 2 || len(m) != 2 {
+		return 0, false
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hh < 0 || mm < 0 || mm > 59 || hh > 24 || (hh == 24 && mm != 0) {
+		return 0, false
+	}
+	return hh*60 + mm, true
+}
+
+// validateAccessWindows returns a message describing the first invalid
+// window, or "".
+func validateAccessWindows(windows []AccessWindow) string {
+	if len(windows) > maxAccessWindows {
+		return fmt.Sprintf("At most %d access windows are allowed", maxAccessWindows)
+	}
+	for _, w := range windows {
+		start, ok1 := parseClock(w.Start)
+		end, ok2 := parseClock(w.End)
+		if !ok1 || !ok2 || start == 24*60 {
+			return "Access window times must look like 08:00"
+		}
+		if start == end {
+			return "Access window start and end must differ"
+		}
+		for _, d := range w.Days {
+			if d < time.Sunday || d > time.Saturday {
+				return "Access window days must be 0 (Sunday) to 6 (Saturday)"
+			}
+		}
+	}
+	return ""
+}
+
+// onDay reports whether the window starts on day.
+func (w AccessWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether t falls inside the window. Windows are checked
+// by wall-clock time, so they follow daylight saving changes.
+func (w AccessWindow) contains(t time.Time) bool {
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return w.onDay(t.Weekday()) && now >= start && now < end
+	}
+	// Past midnight: the evening part belongs to today, the morning part to
+	// a window that started yesterday.
+	return (w.onDay(t.Weekday()) && now >= start) || (w.onDay((t.Weekday()+6)%7) && now < end)
+}
+
+// windowsAllow reports whether t is inside any of the windows; no windows
+// allow any time.
+func windowsAllow(windows []AccessWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatAccessWindows renders windows for the audit log, e.g.
+// "Mon,Tue 08:00-17:00; any day 20:00-22:00".
+func formatAccessWindows(windows []AccessWindow) string {
+	if len(windows) == 0 {
+		return "any time"
+	}
+	parts := make([]string, len(windows))
+	for i, w := range windows {
+		days := "any day"
+		if len(w.Days) > 0 {
+			names := make([]string, len(w.Days))
+			for j, d := range w.Days {
+				names[j] = d.String()[:3]
+			}
+			days = strings.Join(names, ",")
+		}
+		parts[i] = fmt.Sprintf("%s %s-%s", days, w.Start, w.End)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// inAccessHours reports whether the voucher may be used at t.
+func (v *Voucher) inAccessHours(t time.Time) bool {
+	return windowsAllow(v.AccessWindows, t)
+}
+
+// portalClosed reports whether the site schedule has the portal closed at t.
+func portalClosed(t time.Time) bool {
+	return !windowsAllow(siteSchedule.Windows, t)
+}
+
+// closedMessage is the admin's closed message, or the translated default.
+func closedMessage(lang string) string {
+	if siteSchedule.ClosedMessage != "" {
+		return siteSchedule.ClosedMessage
+	}
+	return translate(lang, "portal_closed")
+}
+
+// scheduleStatus is the site schedule as the admin API returns it, with
+// whether the portal is closed right now.
+func scheduleStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"windows":        siteSchedule.Windows,
+		"closed_message": siteSchedule.ClosedMessage,
+		"closed":         portalClosed(time.Now()),
+	}
+}
+
+func adminScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(scheduleStatus())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var s SiteSchedule
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if errMsg := validateAccessWindows(s.Windows); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
+	s.ClosedMessage = strings.TrimSpace(s.ClosedMessage)
+	if len(s.ClosedMessage) > 300 {
+		http.Error(w, `{"error": "Closed message must be at most 300 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if s.Windows == nil {
+		s.Windows = []AccessWindow{}
+	}
+
+	old := siteSchedule
+	siteSchedule = s
+	if err := saveSchedule(); err != nil {
+		siteSchedule = old
+		http.Error(w, `{"error": "Could not save schedule"}`, http.StatusInternalServerError)
+		return
+	}
+	// End sessions outside the new hours now rather than on the next tick.
+	go reconcileSessions()
+	json.NewEncoder(w).Encode(scheduleStatus())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"time"
+)
+
+// maxSessionRecords bounds the session history kept on flash.
+const maxSessionRecords = 10000
+
+// Data is accounted in bytes. Voucher allowances are in MB of 1024*1024 bytes,
+// as the admin panel and portal show them; NDS client counters and openNDS
+// quotas are in kB of 1000 bytes.
+const (
+	bytesPerMB    = 1024 * 1024
+	bytesPerNDSKB = 1000
+)
+
+// Session is one period of a client being authenticated in NoDogSplash, as
+// reported by BinAuth events. It is kept separately from the voucher so the
+// full history of who used a voucher, when and how much survives.
+type Session struct {
+	ID        int       `json:"id"`
+	VoucherID int       `json:"voucher_id,omitempty"` // 0 when no voucher was active
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end,omitempty"`
+	EndReason string    `json:"end_reason,omitempty"` // BinAuth deauth event, e.g. "idle_deauth"
+	BytesDown int64     `json:"bytes_down"`
+	BytesUp   int64     `json:"bytes_up"`
+}
+
+var sessionsCache []Session
+
+func loadSessions() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	sessionsCache = []Session{}
+	return readJSONFile(dataPath("sessions.json"), &sessionsCache)
+}
+
+func saveSessions() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if len(sessionsCache) > maxSessionRecords {
+		sessionsCache = append([]Session(nil), sessionsCache[len(sessionsCache)-maxSessionRecords:]...)
+	}
+	return writeJSONFile(dataPath("sessions.json"), sessionsCache)
+}
+
+// openSessionFor returns the MAC's session that has not ended yet, or nil.
+func openSessionFor(mac string) *Session {
+	for i := len(sessionsCache) - 1; i >= 0; i-- {
+		if sessionsCache[i].MAC == mac && sessionsCache[i].End.IsZero() {
+			return &sessionsCache[i]
+		}
+	}
+	return nil
+}
+
+// startSession records a client becoming authenticated. An already open
+// session for the MAC is kept rather than duplicated.
+func startSession(mac string, start time.Time) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	return addSession(mac, start)
+}
+
+// addSession opens a session for mac; the caller holds cacheMutex.
+func addSession(mac string, start time.Time) error {
+	if openSessionFor(mac) != nil {
+		return nil
+	}
+	id := 1
+	if n := len(sessionsCache); n > 0 {
+		id = sessionsCache[n-1].ID + 1
+	}
+	s := Session{ID: id, MAC: mac, Start: start}
+	if v, d := findActiveVoucher(mac); v != nil {
+		s.VoucherID = v.ID
+		s.IP = d.IP
+	}
+	sessionsCache = append(sessionsCache, s)
+	return saveSessions()
+}
+
+// endSession closes the MAC's open session with the final byte counters. A
+// deauth without a matching start (e.g. the backend was down at auth time)
+// is still recorded, starting at the time NDS reports.
+func endSession(mac, reason string, start, end time.Time, bytesDown, bytesUp int64) error {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	s := openSessionFor(mac)
+	if s == nil {
+		if err := addSession(mac, start); err != nil {
+			return err
+		}
+		s = openSessionFor(mac)
+	}
+	s.End = end
+	s.EndReason = reason
+	s.BytesDown = bytesDown
+	s.BytesUp = bytesUp
+	return saveSessions()
+}
+
+// getSessions returns the recorded sessions matching a voucher ID and/or MAC,
+// newest first. Zero values match everything.
+func getSessions(voucherID int, mac string) []Session {
+	mac = normalizeMAC(mac)
+	sessions := make([]Session, 0)
+	for i := len(sessionsCache) - 1; i >= 0; i-- {
+		s := sessionsCache[i]
+		if (voucherID == 0 || s.VoucherID == voucherID) && (mac == "" || s.MAC == mac) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// recordedBytes sums the traffic of the voucher's finished sessions. For shared
+// codes only the given device's sessions count, as each device has its own
+// allowance.
+func recordedBytes(v *Voucher, d *VoucherDevice) int64 {
+	var total int64
+	for _, s := range sessionsCache {
+		if s.VoucherID != v.ID {
+			continue
+		}
+		if v.IsReusable && (d == nil || s.MAC != d.MAC) {
+			continue
+		}
+		total += s.BytesDown + s.BytesUp
+	}
+	return total
+}
+
+// remainingDataKB returns how many NDS kilobytes a device may still transfer
+// on the voucher, or 0 when its data is unlimited.
+func remainingDataKB(v *Voucher, d *VoucherDevice) int64 {
+	limit := v.dataLimitFor(d)
+	if limit <= 0 {
+		return 0
+	}
+	left := (int64(limit)*bytesPerMB - recordedBytes(v, d)) / bytesPerNDSKB
+	if left < 1 {
+		left = 1
+	}
+	return left
+}
+
+// dataLimitReached reports whether a device has used up the voucher's data
+// allowance, counting liveBytes from a session still in progress.
+func dataLimitReached(v *Voucher, d *VoucherDevice, liveBytes int64) bool {
+	limit := v.dataLimitFor(d)
+	if limit <= 0 {
+		return false
+	}
+	return recordedBytes(v, d)+liveBytes >= int64(limit)*bytesPerMB
+}
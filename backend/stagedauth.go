@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagedAuthTTL is how long a staged authentication waits for the browser to
+// reach the NDS auth URL and NDS to run binauth.sh.
+const stagedAuthTTL = 30 * time.Second
+
+// stagedAuth is a voucher redemption waiting for NoDogSplash to confirm it.
+// It is keyed by a one-time nonce that travels as the BinAuth password in the
+// auth URL next to the client's NDS token. NDS only runs binauth.sh when that
+// token belongs to the client, and binauth.sh hands back the nonce and MAC,
+// so a staged auth can only be consumed by the device it was staged for.
+type stagedAuth struct {
+	MAC     string
+	Seconds int
+	Expires time.Time
+}
+
+var (
+	stagedAuths      = make(map[string]*stagedAuth)
+	stagedAuthsMutex = &sync.Mutex{}
+)
+
+// stageAuth stages mac for seconds of access and returns the nonce binauth.sh
+// has to present for it.
+func stageAuth(mac string, seconds int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+
+	stagedAuthsMutex.Lock()
+	stagedAuths[nonce] = &stagedAuth{MAC: mac, Seconds: seconds, Expires: time.Now().Add(stagedAuthTTL)}
+	stagedAuthsMutex.Unlock()
+	return nonce, nil
+}
+
+// consumeStagedAuth returns the seconds staged under nonce if it was staged
+// for mac and has not expired. A nonce can only be used once.
+func consumeStagedAuth(nonce, mac string) (int, bool) {
+	stagedAuthsMutex.Lock()
+	defer stagedAuthsMutex.Unlock()
+	s, ok := stagedAuths[nonce]
+	if !ok || s.MAC != mac || time.Now().After(s.Expires) {
+		return 0, false
+	}
+	delete(stagedAuths, nonce)
+	return s.Seconds, true
+}
+
+// unstageMAC drops every staged authentication for mac.
+func unstageMAC(mac string) {
+	stagedAuthsMutex.Lock()
+	defer stagedAuthsMutex.Unlock()
+	for nonce, s := range stagedAuths {
+		if s.MAC == mac {
+			delete(stagedAuths, nonce)
+		}
+	}
+}
+
+// isStaged reports whether a MAC has a staged authentication pending.
+func isStaged(mac string) bool {
+	stagedAuthsMutex.Lock()
+	defer stagedAuthsMutex.Unlock()
+	for _, s := range stagedAuths {
+		if s.MAC == mac && time.Now().Before(s.Expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// runStagedAuthJanitor drops expired staged authentications.
+func runStagedAuthJanitor() {
+	for range time.Tick(stagedAuthTTL) {
+		now := time.Now()
+		stagedAuthsMutex.Lock()
+		for nonce, s := range stagedAuths {
+			if now.After(s.Expires) {
+				delete(stagedAuths, nonce)
+			}
+		}
+		stagedAuthsMutex.Unlock()
+	}
+}
+
+// withNonce adds the BinAuth credentials carrying nonce to an NDS auth URL.
+// NoDogSplash hands them to binauth.sh as its username and password
+// arguments; openNDS has neither and passes the custom string instead.
+func withNonce(authURL, nonce string) string {
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+	if daemon == daemonOpenNDS {
+		return authURL + sep + url.Values{"custom": {nonce}}.Encode()
+	}
+	return authURL + sep + url.Values{"username": {"voucher"}, "password": {nonce}}.Encode()
+}
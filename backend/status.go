@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// callerMAC identifies the client making a portal request from its IP, so a
+// browser cannot act for another device. Only off the router, where there is
+// no NDS to ask, is the mac query parameter used instead.
+func callerMAC(r *http.Request) string {
+	if mac := macForIP(remoteIP(r)); mac != "" {
+		return mac
+	}
+	if _, err := nds.Status(); err == errNDSUnavailable {
+		return normalizeMAC(r.URL.Query().Get("mac"))
+	}
+	return ""
+}
+
+// customerStatus is what a connected customer sees about their own session.
+type customerStatus struct {
+	Connected        bool   `json:"connected"`
+	Plan             string `json:"plan,omitempty"` // voucher name
+	RemainingSeconds int64  `json:"remaining_seconds,omitempty"`
+	DataUsed         int64  `json:"data_used"`            // bytes
+	DataLimit        int64  `json:"data_limit,omitempty"` // bytes, 0 = unlimited
+}
+
+// statusHandler reports the caller's own voucher session: plan, time left and
+// data used. A free trial shows as its own plan. Callers without a running
+// voucher or trial get connected: false.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	mac := callerMAC(r)
+	if mac == "" {
+		json.NewEncoder(w).Encode(customerStatus{})
+		return
+	}
+	for _, s := range getActiveSessions() {
+		if s.MAC != mac {
+			continue
+		}
+		seconds, _ := s.remaining(time.Now())
+		status := customerStatus{Connected: true, RemainingSeconds: int64(seconds)}
+		if v, d := findActiveVoucher(mac); v != nil {
+			status.Plan = v.Name
+			status.DataUsed = recordedBytes(v, d) + liveBytes(mac)
+			status.DataLimit = int64(v.dataLimitFor(d)) * bytesPerMB
+		} else if isOnTrial(mac) {
+			status.Plan = translate(requestLanguage(r), "trial_plan")
+		}
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	json.NewEncoder(w).Encode(customerStatus{})
+}
+
+// logoutHandler lets a customer end their own session. The voucher keeps its
+// remaining time; entering it again logs the device back in.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	mac := callerMAC(r)
+	if mac == "" {
+		writePortalError(w, r, http.StatusBadRequest, newPortalError("device_not_found"))
+		return
+	}
+	if failed := disconnectClients([]string{mac}); len(failed) > 0 {
+		writePortalError(w, r, http.StatusBadGateway, newPortalError("logout_failed"))
+		return
+	}
+	log.Printf("%s logged itself out", mac)
+	w.Write([]byte(`{"status": "success"}`))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogoutIsNotUndoneByFAS(t *testing.T) {
+	f := useFakeNDS(t, runningVoucher(1, testMAC, 60))
+	f.AddClient(testMAC, "10.0.0.2")
+	f.Auth(testMAC, 3600)
+
+	fas := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/fas?tok=020000000001&authaction=http://10.0.0.1:2050/nodogsplash_auth/", nil)
+		r.RemoteAddr = "10.0.0.2:40000"
+		w := httptest.NewRecorder()
+		fasHandler(w, r)
+		return w
+	}
+	// Before logging out, the captive-portal probe goes straight back to NDS.
+	f.Deauth(testMAC)
+	if loc := fas().Header().Get("Location"); strings.HasPrefix(loc, "/?sid=") {
+		t.Fatalf("redirect = %q, want a staged NDS auth for the running session", loc)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.RemoteAddr = "10.0.0.2:40000"
+	w := httptest.NewRecorder()
+	logoutHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d: %s", w.Code, w.Body)
+	}
+
+	w = fas()
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.HasPrefix(loc, "/?sid=") {
+		t.Errorf("after logout: %d redirect to %q, want the portal login", w.Code, loc)
+	}
+	if !isKicked(testMAC) {
+		t.Error("kicked = false after /fas, want the logout to stick")
+	}
+}
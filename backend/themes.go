@@ -0,0 +1,365 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Limits for uploaded theme packages. A portal page has to load over a
+// connection that is not authenticated yet, so themes are kept small.
+const (
+	maxThemeUpload   = 2 << 20 // zip as uploaded
+	maxThemeUnpacked = 8 << 20 // all files once extracted
+	maxThemeFiles    = 64
+)
+
+var themeNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,40}$`)
+
+// builtinThemes ship with the portal as single files in themes/ and cannot be
+// deleted.
+var builtinThemes = map[string]ThemeInfo{
+	"default":   {Label: "RoseNet (Matrix Pink)", Description: "Dark grid with an orange glow"},
+	"modern":    {Label: "QuickConnect (Clean Modern)", Description: "Light card layout"},
+	"corporate": {Label: "GlobalNet (ISP Corporate)", Description: "ISP style with a top navigation bar"},
+	"music":     {Label: "AsifNET (Retro Music)", Description: "Retro terminal with background music, in Bengali"},
+}
+
+// ThemeInfo describes an installed theme. Uploaded themes take it from the
+// theme.json in their package.
+type ThemeInfo struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	Version     string `json:"version"`
+	Builtin     bool   `json:"builtin"`
+	Active      bool   `json:"active"`
+}
+
+// themeAssetTypes are the files a theme package may contain.
+var themeAssetTypes = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".json": true, ".txt": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".svg": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".mp3": true, ".ogg": true,
+}
+
+// themeData is what theme templates are rendered with: the branding, the
+// URL prefix of the theme's own files for packages with assets, the page
+// language with its portal messages (locales/<Lang>.json), the free trial
+// on offer (nil when click-through mode is off), and whether the site
+// schedule has the portal closed, with the message to show instead.
+type themeData struct {
+	Branding
+	Assets        string
+	Lang          string
+	Text          map[string]string
+	Trial         *trialOffer
+	Closed        bool
+	ClosedMessage string
+}
+
+// themesDir is where uploaded themes are installed. Built-in themes are
+// embedded in the binary, though a copy here still takes precedence.
+func themesDir() string {
+	return filepath.Join(frontendDir, "themes")
+}
+
+// themePath returns the template file of a theme within webFS, or "" when the
+// name is not a valid theme name or the theme is not installed. Built-in
+// themes are single files; uploaded ones are directories with an index.html.
+func themePath(theme string) string {
+	if !themeNamePattern.MatchString(theme) {
+		return ""
+	}
+	for _, p := range []string{"themes/" + theme + ".html", "themes/" + theme + "/index.html"} {
+		if _, err := fs.Stat(webFS, p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// renderTheme executes a theme template with the current branding. Themes are
+// parsed on every request so edits to the HTML show up without a restart;
+// the portal sees little enough traffic for that not to matter.
+func renderTheme(w http.ResponseWriter, r *http.Request, theme string) {
+	path := themePath(theme)
+	tmpl, err := template.ParseFS(webFS, path)
+	if err != nil {
+		log.Printf("[renderTheme] Failed to parse %s: %v", path, err)
+		http.Error(w, "Portal page unavailable", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	lang := requestLanguage(r)
+	data := themeData{Branding: brandingCache, Assets: "/themes/" + theme + "/", Lang: lang, Text: portalText(lang),
+		Trial: currentTrialOffer()}
+	if portalClosed(time.Now()) {
+		data.Closed, data.ClosedMessage, data.Trial = true, closedMessage(lang), nil
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("[renderTheme] Failed to render %s: %v", path, err)
+		http.Error(w, "Portal page unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Language", lang)
+	w.Write(buf.Bytes())
+}
+
+// listThemes returns the built-in themes followed by the uploaded ones.
+func listThemes() []ThemeInfo {
+	active, _ := getSetting("active_theme")
+	if active == "" {
+		active = "default"
+	}
+
+	themes := []ThemeInfo{}
+	for _, name := range []string{"default", "modern", "corporate", "music"} {
+		if themePath(name) == "" {
+			continue
+		}
+		info := builtinThemes[name]
+		info.Name, info.Builtin, info.Active = name, true, name == active
+		themes = append(themes, info)
+	}
+
+	entries, err := os.ReadDir(themesDir())
+	if err != nil {
+		return themes
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if _, builtin := builtinThemes[name]; builtin || !e.IsDir() || themePath(name) == "" {
+			continue
+		}
+		var info ThemeInfo
+		if data, err := os.ReadFile(filepath.Join(themesDir(), name, "theme.json")); err == nil {
+			json.Unmarshal(data, &info)
+		}
+		if info.Label == "" {
+			info.Label = name
+		}
+		info.Name, info.Builtin, info.Active = name, false, name == active
+		themes = append(themes, info)
+	}
+	return themes
+}
+
+// themeFiles validates the entries of a theme package and maps the path each
+// will be extracted to onto its zip entry. A package may wrap everything in a
+// single top-level folder, as zipping a directory usually does.
+func themeFiles(zr *zip.Reader) (map[string]*zip.File, error) {
+	files := map[string]*zip.File{}
+	var total uint64
+	for _, f := range zr.File {
+		name := f.Name
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || path.Base(name) == ".DS_Store" {
+			continue
+		}
+		clean := path.Clean(name)
+		if strings.Contains(name, `\`) || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("invalid path in package: %s", name)
+		}
+		if !themeAssetTypes[strings.ToLower(path.Ext(clean))] {
+			return nil, fmt.Errorf("file type not allowed: %s", name)
+		}
+		total += f.UncompressedSize64
+		if total > maxThemeUnpacked {
+			return nil, fmt.Errorf("package is larger than %d MB unpacked", maxThemeUnpacked>>20)
+		}
+		files[clean] = f
+	}
+	if len(files) > maxThemeFiles {
+		return nil, fmt.Errorf("package has more than %d files", maxThemeFiles)
+	}
+
+	if _, ok := files["index.html"]; ok {
+		return files, nil
+	}
+	// Strip a single wrapping folder.
+	var prefix string
+	for name := range files {
+		dir, _, found := strings.Cut(name, "/")
+		if !found || (prefix != "" && dir != prefix) {
+			return nil, fmt.Errorf("package must contain index.html")
+		}
+		prefix = dir
+	}
+	stripped := map[string]*zip.File{}
+	for name, f := range files {
+		stripped[strings.TrimPrefix(name, prefix+"/")] = f
+	}
+	if _, ok := stripped["index.html"]; !ok {
+		return nil, fmt.Errorf("package must contain index.html")
+	}
+	return stripped, nil
+}
+
+// installTheme unpacks a theme package into themes/<name>/, replacing an
+// uploaded theme of the same name. index.html has to render with empty
+// branding before anything is written, so a broken theme cannot be
+// activated. The package is unpacked next to the old copy and swapped in
+// with a rename.
+func installTheme(name string, pkg []byte) error {
+	if !themeNamePattern.MatchString(name) {
+		return fmt.Errorf("theme name must be lowercase letters, digits, - or _")
+	}
+	if _, ok := builtinThemes[name]; ok {
+		return fmt.Errorf("%s is a built-in theme", name)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		return fmt.Errorf("not a valid zip file")
+	}
+	files, err := themeFiles(zr)
+	if err != nil {
+		return err
+	}
+
+	contents := make(map[string][]byte, len(files))
+	var total int64
+	for name, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("cannot read %s", name)
+		}
+		// The sizes in the zip headers are not trusted.
+		data, err := io.ReadAll(io.LimitReader(rc, maxThemeUnpacked-total+1))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read %s", name)
+		}
+		total += int64(len(data))
+		if total > maxThemeUnpacked {
+			return fmt.Errorf("package is larger than %d MB unpacked", maxThemeUnpacked>>20)
+		}
+		contents[name] = data
+	}
+
+	tmpl, err := template.New("index.html").Parse(string(contents["index.html"]))
+	if err != nil {
+		return fmt.Errorf("index.html: %v", err)
+	}
+	if err := tmpl.Execute(io.Discard, themeData{Assets: "/themes/" + name + "/", Lang: "en", Text: portalText("en")}); err != nil {
+		return fmt.Errorf("index.html: %v", err)
+	}
+	if meta, ok := contents["theme.json"]; ok {
+		var info ThemeInfo
+		if err := json.Unmarshal(meta, &info); err != nil {
+			return fmt.Errorf("theme.json is not valid JSON")
+		}
+	}
+
+	dest := filepath.Join(themesDir(), name)
+	tmp := dest + ".new"
+	os.RemoveAll(tmp)
+	for rel, data := range contents {
+		target := filepath.Join(tmp, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	os.RemoveAll(dest)
+	if err := os.Rename(tmp, dest); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+// deleteTheme removes an uploaded theme. Built-in themes and the active theme
+// are kept; switch to another theme first.
+func deleteTheme(name string) error {
+	if _, ok := builtinThemes[name]; ok {
+		return fmt.Errorf("Built-in themes cannot be deleted")
+	}
+	if !themeNamePattern.MatchString(name) {
+		return fmt.Errorf("Theme not found")
+	}
+	dir := filepath.Join(themesDir(), name)
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		return fmt.Errorf("Theme not found")
+	}
+	if active, _ := getSetting("active_theme"); active == name {
+		return fmt.Errorf("Theme is active, switch to another theme first")
+	}
+	return os.RemoveAll(dir)
+}
+
+// adminThemesHandler lists the installed themes.
+func adminThemesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listThemes())
+}
+
+// adminThemeUploadHandler takes a zip in the multipart "theme" field and an
+// optional "name"; without one the zip's file name is used.
+func adminThemeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxThemeUpload+4096)
+	file, header, err := r.FormFile("theme")
+	if err != nil {
+		http.Error(w, `{"error": "Theme package missing or larger than 2 MB"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	pkg, err := io.ReadAll(io.LimitReader(file, maxThemeUpload+1))
+	if err != nil || len(pkg) > maxThemeUpload {
+		http.Error(w, `{"error": "Theme package must be at most 2 MB"}`, http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = strings.ToLower(strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)))
+	}
+	if err := installTheme(name, pkg); err != nil {
+		log.Printf("[adminThemeUploadHandler] Rejected theme %s: %v", name, err)
+		http.Error(w, fmt.Sprintf(`{"error": "Invalid theme: %s"}`, strings.ReplaceAll(err.Error(), `"`, `'`)), http.StatusBadRequest)
+		return
+	}
+	log.Printf("[adminThemeUploadHandler] Installed theme %s", name)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "name": name})
+}
+
+func adminThemeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := deleteTheme(payload.Name); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	log.Printf("[adminThemeDeleteHandler] Deleted theme %s", payload.Name)
+	w.Write([]byte(`{"status": "success"}`))
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTermsAcceptances bounds the acceptance records kept on flash.
+const maxTermsAcceptances = 10000
+
+// maxTermsLength caps the terms text shown on the portal.
+const maxTermsLength = 20000
+
+// TrialConfig is the click-through mode: a device may accept the current terms
+// of use for a short free session without a voucher.
+type TrialConfig struct {
+	Enabled         bool `json:"enabled"`
+	Minutes         int  `json:"minutes"`
+	CooldownMinutes int  `json:"cooldown_minutes"` // wait after a trial ends before the same MAC gets another
+	MACDailyLimit   int  `json:"mac_daily_limit"`  // trials per MAC per day, 0 = unlimited
+	DailyLimit      int  `json:"daily_limit"`      // trials per day for all devices, 0 = unlimited
+	// Terms holds every published version of the terms, oldest first; the
+	// last one is current. Old versions stay so acceptances can be traced
+	// back to the text that was agreed to.
+	Terms []TermsVersion `json:"terms"`
+}
+
+// TermsVersion is one published text of the terms of use.
+type TermsVersion struct {
+	Version   int       `json:"version"`
+	Text      string    `json:"text"`
+	Published time.Time `json:"published"`
+}
+
+// TermsAcceptance records a device accepting a terms version, and the free
+// trial it was given for it.
+type TermsAcceptance struct {
+	ID           int       `json:"id"`
+	MAC          string    `json:"mac"`
+	IP           string    `json:"ip,omitempty"`
+	TermsVersion int       `json:"terms_version"`
+	AcceptedAt   time.Time `json:"accepted_at"`
+	TrialUntil   time.Time `json:"trial_until"`
+}
+
+var (
+	trialConfig      = TrialConfig{Minutes: 15, CooldownMinutes: 24 * 60}
+	termsAcceptances []TermsAcceptance
+	trialMutex       = &sync.Mutex{}
+)
+
+func loadTrial() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if err := readJSONFile(dataPath("trial.json"), &trialConfig); err != nil {
+		return err
+	}
+	termsAcceptances = []TermsAcceptance{}
+	return readJSONFile(dataPath("terms_acceptances.json"), &termsAcceptances)
+}
+
+func saveTrialConfig() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return writeJSONFile(dataPath("trial.json"), trialConfig)
+}
+
+func saveTermsAcceptances() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if len(termsAcceptances) > maxTermsAcceptances {
+		termsAcceptances = append([]TermsAcceptance(nil), termsAcceptances[len(termsAcceptances)-maxTermsAcceptances:]...)
+	}
+	return writeJSONFile(dataPath("terms_acceptances.json"), termsAcceptances)
+}
+
+// currentTerms returns the terms version customers have to accept, or nil
+// before any have been published.
+func currentTerms() *TermsVersion {
+	if n := len(trialConfig.Terms); n > 0 {
+		return &trialConfig.Terms[n-1]
+	}
+	return nil
+}
+
+// trialOffer is what the portal themes show of the click-through mode.
+type trialOffer struct {
+	Minutes      int
+	TermsVersion int
+	TermsText    string
+}
+
+// currentTrialOffer returns the free trial on offer, or nil when the mode is
+// off or there are no terms to accept.
+func currentTrialOffer() *trialOffer {
+	terms := currentTerms()
+	if !trialConfig.Enabled || terms == nil {
+		return nil
+	}
+	return &trialOffer{Minutes: trialConfig.Minutes, TermsVersion: terms.Version, TermsText: terms.Text}
+}
+
+// validateTrialConfig returns a message describing the first invalid field.
+func validateTrialConfig(c *TrialConfig) string {
+	if c.Minutes < 1 || c.Minutes > 24*60 {
+		return "Trial duration must be between 1 and 1440 minutes"
+	}
+	if c.CooldownMinutes < 0 || c.CooldownMinutes > 30*24*60 {
+		return "Cooldown must be between 0 and 43200 minutes"
+	}
+	if c.MACDailyLimit < 0 || c.DailyLimit < 0 {
+		return "Daily limits cannot be negative"
+	}
+	return ""
+}
+
+// startOfDay is local midnight before t, when the daily limits reset.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// grantTrial checks the limits for mac and records its acceptance of the
+// given terms version, returning the trial's expiry. A refused trial comes
+// back as a portalError the customer can act on.
+func grantTrial(mac, ip string, termsVersion int) (time.Time, *portalError) {
+	trialMutex.Lock()
+	defer trialMutex.Unlock()
+
+	terms := currentTerms()
+	if !trialConfig.Enabled || terms == nil {
+		return time.Time{}, newPortalError("trial_disabled")
+	}
+	if termsVersion != terms.Version {
+		return time.Time{}, newPortalError("terms_changed")
+	}
+	now := time.Now()
+	if portalClosed(now) {
+		return time.Time{}, newPortalError("portal_closed")
+	}
+
+	today := startOfDay(now)
+	cooldown := time.Duration(trialConfig.CooldownMinutes) * time.Minute
+	macToday, allToday := 0, 0
+	for _, a := range termsAcceptances {
+		if a.MAC == mac {
+			if next := a.TrialUntil.Add(cooldown); now.Before(next) {
+				return time.Time{}, newPortalError("trial_cooldown", int(next.Sub(now).Minutes())+1)
+			}
+		}
+		if !a.AcceptedAt.Before(today) {
+			allToday++
+			if a.MAC == mac {
+				macToday++
+			}
+		}
+	}
+	if trialConfig.MACDailyLimit > 0 && macToday >= trialConfig.MACDailyLimit {
+		return time.Time{}, newPortalError("trial_used_today")
+	}
+	if trialConfig.DailyLimit > 0 && allToday >= trialConfig.DailyLimit {
+		return time.Time{}, newPortalError("trial_limit")
+	}
+
+	id := 1
+	if n := len(termsAcceptances); n > 0 {
+		id = termsAcceptances[n-1].ID + 1
+	}
+	until := now.Add(time.Duration(trialConfig.Minutes) * time.Minute)
+	termsAcceptances = append(termsAcceptances, TermsAcceptance{
+		ID: id, MAC: mac, IP: ip, TermsVersion: terms.Version, AcceptedAt: now, TrialUntil: until,
+	})
+	if err := saveTermsAcceptances(); err != nil {
+		log.Printf("[grantTrial] Failed to save acceptance for %s: %v", mac, err)
+		termsAcceptances = termsAcceptances[:len(termsAcceptances)-1]
+		return time.Time{}, newPortalError("internal_error")
+	}
+	return until, nil
+}
+
+// activeTrials returns the running free trials, one per MAC, with their
+// expiry.
+func activeTrials() []activeSession {
+	trialMutex.Lock()
+	defer trialMutex.Unlock()
+	now := time.Now()
+	latest := make(map[string]time.Time)
+	for _, a := range termsAcceptances {
+		if now.Before(a.TrialUntil) && a.TrialUntil.After(latest[a.MAC]) {
+			latest[a.MAC] = a.TrialUntil
+		}
+	}
+	sessions := make([]activeSession, 0, len(latest))
+	for mac, expiry := range latest {
+		sessions = append(sessions, activeSession{MAC: mac, Expiry: expiry})
+	}
+	return sessions
+}
+
+// isOnTrial reports whether mac has a free trial running.
+func isOnTrial(mac string) bool {
+	for _, s := range activeTrials() {
+		if s.MAC == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// getTermsAcceptances returns the acceptance records, newest first,
+// optionally for one MAC.
+func getTermsAcceptances(mac string) []TermsAcceptance {
+	mac = normalizeMAC(mac)
+	trialMutex.Lock()
+	defer trialMutex.Unlock()
+	records := make([]TermsAcceptance, 0)
+	for i := len(termsAcceptances) - 1; i >= 0; i-- {
+		if mac == "" || termsAcceptances[i].MAC == mac {
+			records = append(records, termsAcceptances[i])
+		}
+	}
+	return records
+}
+
+// termsHandler serves the current terms of use and the trial on offer, for
+// portals that load them instead of using the template fields.
+func termsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	resp := map[string]interface{}{"enabled": false}
+	if offer := currentTrialOffer(); offer != nil {
+		resp = map[string]interface{}{
+			"enabled":       true,
+			"minutes":       offer.Minutes,
+			"terms_version": offer.TermsVersion,
+			"terms":         offer.TermsText,
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// trialHandler gives the client behind the request a free trial once it has
+// accepted the current terms (?terms_version=), and stages it for NDS auth
+// just like a voucher login.
+func trialHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	clientMAC, clientIP, authURL, ok := portalClient(w, r)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("terms_version"))
+	if err != nil || version < 1 {
+		writePortalError(w, r, http.StatusBadRequest, newPortalError("terms_required"))
+		return
+	}
+
+	until, perr := grantTrial(clientMAC, clientIP, version)
+	if perr != nil {
+		log.Printf("[trial] Refused free trial for %s: %s", clientMAC, perr)
+		status := http.StatusForbidden
+		if perr.Code == "terms_changed" {
+			status = http.StatusConflict
+		} else if perr.Code == "internal_error" {
+			status = http.StatusInternalServerError
+		}
+		writePortalError(w, r, status, perr)
+		return
+	}
+	setKicked(clientMAC, false)
+	nonce, err := stageAuth(clientMAC, int(time.Until(until).Seconds()))
+	if err != nil {
+		log.Printf("[trial] Error staging %s: %v", clientMAC, err)
+		writePortalError(w, r, http.StatusInternalServerError, newPortalError("internal_error"))
+		return
+	}
+	log.Printf("[trial] %s accepted terms v%d, free until %s", clientMAC, version, until.Format(time.RFC3339))
+
+	resp := map[string]interface{}{"status": "success", "duration": trialConfig.Minutes}
+	if authURL != "" {
+		resp["auth_url"] = withNonce(authURL, nonce)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// adminTrialRequest is the admin's view of the click-through mode; saving a
+// changed terms_text publishes it as a new version.
+type adminTrialRequest struct {
+	Enabled         bool   `json:"enabled"`
+	Minutes         int    `json:"minutes"`
+	CooldownMinutes int    `json:"cooldown_minutes"`
+	MACDailyLimit   int    `json:"mac_daily_limit"`
+	DailyLimit      int    `json:"daily_limit"`
+	TermsText       string `json:"terms_text"`
+}
+
+func adminTrialHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(trialConfig)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req adminTrialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.TermsText = strings.TrimSpace(req.TermsText)
+	if len(req.TermsText) > maxTermsLength {
+		http.Error(w, fmt.Sprintf(`{"error": "Terms must be at most %d characters"}`, maxTermsLength), http.StatusBadRequest)
+		return
+	}
+
+	trialMutex.Lock()
+	defer trialMutex.Unlock()
+	c := trialConfig
+	c.Enabled, c.Minutes, c.CooldownMinutes = req.Enabled, req.Minutes, req.CooldownMinutes
+	c.MACDailyLimit, c.DailyLimit = req.MACDailyLimit, req.DailyLimit
+	if errMsg := validateTrialConfig(&c); errMsg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errMsg), http.StatusBadRequest)
+		return
+	}
+	terms := currentTerms()
+	if req.TermsText != "" && (terms == nil || terms.Text != req.TermsText) {
+		version := 1
+		if terms != nil {
+			version = terms.Version + 1
+		}
+		c.Terms = append(append([]TermsVersion(nil), c.Terms...), TermsVersion{Version: version, Text: req.TermsText, Published: time.Now()})
+		log.Printf("[adminTrialHandler] Published terms of use v%d", version)
+	}
+	if c.Enabled && len(c.Terms) == 0 {
+		http.Error(w, `{"error": "Publish terms of use before enabling free trials"}`, http.StatusBadRequest)
+		return
+	}
+
+	old := trialConfig
+	trialConfig = c
+	if err := saveTrialConfig(); err != nil {
+		trialConfig = old
+		http.Error(w, `{"error": "Could not save trial settings"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(trialConfig)
+}
+
+func adminTermsAcceptancesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getTermsAcceptances(r.URL.Query().Get("mac")))
+}
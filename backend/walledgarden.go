@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWalledGardenEntries keeps the firewall rule set NDS installs small.
+const maxWalledGardenEntries = 50
+
+// walledGardenRefreshInterval is how often hostnames in the walled garden are
+// resolved again. CDN-hosted pages move between addresses, and the firewall
+// rules only hold the addresses found when they were written.
+const walledGardenRefreshInterval = 15 * time.Minute
+
+// basePreauthRules are always allowed before authentication: the portal
+// itself and DNS. They match what install.sh writes.
+var basePreauthRules = []string{
+	"allow tcp port 7891",
+	"allow udp port 7891",
+	"allow tcp port 53",
+	"allow udp port 53",
+}
+
+// WalledGardenEntry is a destination unauthenticated clients may reach, such
+// as a payment page or a social login provider.
+type WalledGardenEntry struct {
+	Host     string `json:"host"`           // hostname, IP address or CIDR
+	Protocol string `json:"protocol"`       // tcp, udp or all
+	Port     int    `json:"port,omitempty"` // 0 = every port
+	Note     string `json:"note,omitempty"`
+}
+
+var walledGardenCache []WalledGardenEntry
+
+// walledGardenMutex guards walledGardenCache and serialises applying it, from
+// the admin API and from the refresher.
+var walledGardenMutex = &sync.Mutex{}
+
+var hostnamePattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// errNDSConfigMissing is returned when there is no NoDogSplash config to
+// render into, i.e. in dev.
+var errNDSConfigMissing = errors.New("NoDogSplash config not found")
+
+func loadWalledGarden() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	walledGardenCache = []WalledGardenEntry{}
+	return readJSONFile(dataPath("walledgarden.json"), &walledGardenCache)
+}
+
+func saveWalledGarden() error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return writeJSONFile(dataPath("walledgarden.json"), walledGardenCache)
+}
+
+// validateWalledGarden normalises the entries in place and returns a message
+// describing the first invalid one.
+func validateWalledGarden(entries []WalledGardenEntry) string {
+	if len(entries) > maxWalledGardenEntries {
+		return fmt.Sprintf("At most %d walled-garden entries are allowed", maxWalledGardenEntries)
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.Host = strings.ToLower(strings.TrimSpace(e.Host))
+		e.Protocol = strings.ToLower(strings.TrimSpace(e.Protocol))
+		if e.Protocol == "" {
+			e.Protocol = "tcp"
+		}
+		if net.ParseIP(e.Host) == nil && !isCIDR(e.Host) && !hostnamePattern.MatchString(e.Host) {
+			return fmt.Sprintf("Invalid host '%s': use a hostname, IP address or CIDR", e.Host)
+		}
+		if e.Protocol != "tcp" && e.Protocol != "udp" && e.Protocol != "all" {
+			return fmt.Sprintf("Invalid protocol '%s' for %s: use tcp, udp or all", e.Protocol, e.Host)
+		}
+		if e.Port < 0 || e.Port > 65535 {
+			return fmt.Sprintf("Invalid port %d for %s", e.Port, e.Host)
+		}
+		if e.Port != 0 && e.Protocol == "all" {
+			return fmt.Sprintf("A port for %s needs protocol tcp or udp", e.Host)
+		}
+	}
+	return ""
+}
+
+func isCIDR(s string) bool {
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// walledGardenRules renders the entries as NoDogSplash firewall rules.
+// Firewall rules need addresses, so hostnames are resolved here; a host that
+// does not resolve is an error rather than being silently dropped.
+func walledGardenRules(entries []WalledGardenEntry) ([]string, error) {
+	rules := append([]string(nil), basePreauthRules...)
+	for _, e := range entries {
+		targets := []string{e.Host}
+		if net.ParseIP(e.Host) == nil && !isCIDR(e.Host) {
+			addrs, err := net.LookupIP(e.Host)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve %s: %v", e.Host, err)
+			}
+			targets = targets[:0]
+			for _, a := range addrs {
+				if a.To4() != nil {
+					targets = append(targets, a.String())
+				}
+			}
+			if len(targets) == 0 {
+				return nil, fmt.Errorf("%s has no IPv4 address", e.Host)
+			}
+			// DNS rotates the order of the answers; sorted rules only change
+			// when the addresses do.
+			slices.Sort(targets)
+			targets = slices.Compact(targets)
+		}
+		for _, t := range targets {
+			rule := "allow " + e.Protocol
+			if e.Port != 0 {
+				rule += fmt.Sprintf(" port %d", e.Port)
+			}
+			rules = append(rules, rule+" to "+t)
+		}
+	}
+	return rules, nil
+}
+
+// applyWalledGarden writes the pre-authentication rules into the NoDogSplash
+// UCI config and restarts NDS. If NDS does not come back up, the previous
+// config is restored and NDS restarted again before returning the error.
+// The caller holds walledGardenMutex.
+func applyWalledGarden(entries []WalledGardenEntry) error {
+	if _, err := os.Stat(daemon.configPath()); os.IsNotExist(err) {
+		return errNDSConfigMissing
+	}
+	rules, err := walledGardenRules(entries)
+	if err != nil {
+		return err
+	}
+	return applyPreauthRules(rules)
+}
+
+// applyPreauthRules does the work of applyWalledGarden for rendered rules.
+func applyPreauthRules(rules []string) error {
+	backup, err := os.ReadFile(daemon.configPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errNDSConfigMissing
+		}
+		return err
+	}
+
+	if err := writePreauthRules(rules); err != nil {
+		return rollbackNDSConfig(backup, err)
+	}
+	if err := restartNDS(); err != nil {
+		return rollbackNDSConfig(backup, err)
+	}
+	log.Printf("[walledGarden] Applied %d pre-authentication rule(s) and restarted NoDogSplash.", len(rules))
+
+	// A restart drops every authenticated client and the runtime MAC lists.
+	go applyMACLists()
+	go reauthSessionsViaNDS()
+	return nil
+}
+
+// runWalledGardenRefresher resolves the walled-garden hostnames at startup and
+// then periodically, rewriting the rules when their addresses changed.
+func runWalledGardenRefresher() {
+	refreshWalledGarden()
+	ticker := time.NewTicker(walledGardenRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refreshWalledGarden()
+	}
+}
+
+// refreshWalledGarden re-renders the walled garden and applies it only if the
+// rules differ from those in the config, as applying restarts NDS and drops
+// every client. Lists of IPs and subnets never change and are skipped, and a
+// host that stops resolving keeps its old rules.
+func refreshWalledGarden() {
+	walledGardenMutex.Lock()
+	defer walledGardenMutex.Unlock()
+
+	hostnames := false
+	for _, e := range walledGardenCache {
+		if net.ParseIP(e.Host) == nil && !isCIDR(e.Host) {
+			hostnames = true
+		}
+	}
+	if !hostnames {
+		return
+	}
+	current, err := readPreauthRules()
+	if err != nil {
+		return // no NDS config, e.g. in dev
+	}
+	rules, err := walledGardenRules(walledGardenCache)
+	if err != nil {
+		log.Printf("[walledGarden] Keeping the current rules: %v", err)
+		return
+	}
+	if slices.Equal(rules, current) {
+		return
+	}
+	log.Printf("[walledGarden] Walled-garden addresses changed, updating the rules.")
+	if err := applyPreauthRules(rules); err != nil {
+		log.Printf("[walledGarden] Failed to update the rules: %v", err)
+	}
+}
+
+// readPreauthRules returns the preauthenticated_users list from the config.
+func readPreauthRules() ([]string, error) {
+	out, err := exec.Command("uci", "-q", "-d", "\n", "get", daemon.uciOption("preauthenticated_users")).Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// writePreauthRules replaces the preauthenticated_users list via uci.
+func writePreauthRules(rules []string) error {
+	option := daemon.uciOption("preauthenticated_users")
+	// Deleting fails harmlessly when the list is already empty.
+	exec.Command("uci", "-q", "delete", option).Run()
+	for _, rule := range rules {
+		if out, err := exec.Command("uci", "add_list", option+"="+rule).CombinedOutput(); err != nil {
+			return fmt.Errorf("uci add_list %q: %v (%s)", rule, err, strings.TrimSpace(string(out)))
+		}
+	}
+	if out, err := exec.Command("uci", "commit", string(daemon)).CombinedOutput(); err != nil {
+		return fmt.Errorf("uci commit: %v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// restartNDS restarts NoDogSplash and waits for ndsctl to answer again.
+func restartNDS() error {
+	if out, err := exec.Command(daemon.initScript(), "restart").CombinedOutput(); err != nil {
+		return fmt.Errorf("restarting NoDogSplash: %v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	var err error
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Second)
+		if _, err = nds.Status(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("NoDogSplash did not come back after restart: %v", err)
+}
+
+// rollbackNDSConfig restores the previous NoDogSplash config after a failed
+// apply and returns the original error.
+func rollbackNDSConfig(backup []byte, cause error) error {
+	log.Printf("[walledGarden] Apply failed, rolling back NoDogSplash config: %v", cause)
+	exec.Command("uci", "revert", string(daemon)).Run()
+	if err := os.WriteFile(daemon.configPath(), backup, 0644); err != nil {
+		log.Printf("[walledGarden] Failed to restore %s: %v", daemon.configPath(), err)
+	} else if err := restartNDS(); err != nil {
+		log.Printf("[walledGarden] NoDogSplash still down after rollback: %v", err)
+	}
+	return cause
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The portal, themes and compiled admin SPA are copied from frontend/ into
+// web/ by scripts/embed-frontend.sh before building, so the binary alone is
+// a complete deployment. web/.gitkeep keeps the pattern valid in a fresh
+// checkout; such a binary serves only what frontendDir provides.
+//
+//go:embed all:web
+var embeddedWeb embed.FS
+
+// maxCachedAsset is the largest file kept in memory with its gzipped copy;
+// bigger ones (e.g. theme music) are streamed from their source.
+const maxCachedAsset = 1 << 20
+
+var errIsDir = errors.New("is a directory")
+
+// overlayFS looks files up in frontendDir on disk first, so uploaded themes
+// and local edits take precedence, then in the embedded copy.
+type overlayFS struct {
+	embedded fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if f, err := os.DirFS(frontendDir).Open(name); err == nil {
+		return f, nil
+	}
+	return o.embedded.Open(name)
+}
+
+var webFS = func() fs.FS {
+	sub, err := fs.Sub(embeddedWeb, "web")
+	if err != nil {
+		panic(err)
+	}
+	return overlayFS{embedded: sub}
+}()
+
+// staticAsset is a served file with its validator. data and gz are only kept
+// for files up to maxCachedAsset; gz is nil when compressing does not help.
+type staticAsset struct {
+	modTime time.Time
+	size    int64
+	etag    string
+	data    []byte
+	gz      []byte
+}
+
+var (
+	assetMutex sync.Mutex
+	assetCache = map[string]*staticAsset{}
+)
+
+// compressible reports whether a file type is worth gzipping; images, fonts
+// and audio are compressed already.
+func compressible(name string) bool {
+	switch path.Ext(name) {
+	case ".html", ".css", ".js", ".mjs", ".json", ".svg", ".txt", ".map":
+		return true
+	}
+	return false
+}
+
+// loadAsset returns the cached asset for name, reloading it when the file's
+// size or modification time changed (embedded files never change).
+func loadAsset(name string) (*staticAsset, error) {
+	f, err := webFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errIsDir
+	}
+
+	assetMutex.Lock()
+	a := assetCache[name]
+	assetMutex.Unlock()
+	if a != nil && a.size == info.Size() && a.modTime.Equal(info.ModTime()) {
+		return a, nil
+	}
+
+	a = &staticAsset{modTime: info.ModTime(), size: info.Size()}
+	h := sha256.New()
+	if info.Size() <= maxCachedAsset {
+		if a.data, err = io.ReadAll(f); err != nil {
+			return nil, err
+		}
+		h.Write(a.data)
+		if compressible(name) && len(a.data) >= 1024 {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(a.data)
+			zw.Close()
+			if buf.Len() < len(a.data) {
+				a.gz = buf.Bytes()
+			}
+		}
+	} else if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	a.etag = hex.EncodeToString(h.Sum(nil)[:8])
+
+	assetMutex.Lock()
+	assetCache[name] = a
+	assetMutex.Unlock()
+	return a, nil
+}
+
+// staticHandler serves files from webFS with ETags, so browsers revalidate
+// instead of downloading again, and gzip for text assets when the client
+// accepts it. Directories serve their index.html.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	a, err := loadAsset(name)
+	if err == errIsDir {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var content io.ReadSeeker
+	switch {
+	case a.gz != nil && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"):
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", `"`+a.etag+`-gz"`)
+		content = bytes.NewReader(a.gz)
+	case a.data != nil:
+		if a.gz != nil {
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		w.Header().Set("ETag", `"`+a.etag+`"`)
+		content = bytes.NewReader(a.data)
+	default:
+		f, err := webFS.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		rs, ok := f.(io.ReadSeeker)
+		if !ok {
+			http.Error(w, "File not seekable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"`+a.etag+`"`)
+		content = rs
+	}
+	http.ServeContent(w, r, name, a.modTime, content)
+}
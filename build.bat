@@ -5,6 +5,10 @@ set GOOS=linux
 set GOARCH=arm64
 set CGO_ENABLED=0
 
+:: Embed the portal, themes and admin panel into the binary.
+:: Build the admin panel first (cd frontend-admin, npm install, npm run build).
+xcopy /E /I /Y /Q frontend backend\web >nul
+
 :: Change to the backend directory to build
 pushd backend
 
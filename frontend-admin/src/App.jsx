@@ -7,12 +7,12 @@ import Topbar from './components/Topbar.jsx'
 import Dashboard from './views/Dashboard.jsx'
 import Vouchers from './views/Vouchers.jsx'
 import Settings from './views/Settings.jsx'
+import Logs from './views/Logs.jsx'
+import Clients from './views/Clients.jsx'
 import { Card, CardTitle } from './components/ui.jsx'
 
 const COMING_SOON = {
-  sessions: 'Active Sessions',
   zones: 'Hotspot Zones',
-  logs: 'User Logs',
   reports: 'Revenue Reports',
 }
 
@@ -134,6 +134,12 @@ export default function App() {
               {view === 'vouchers' && (
                 <Vouchers onUnauthorized={handleLogout} search={search} />
               )}
+              {view === 'sessions' && (
+                <Clients onUnauthorized={handleLogout} search={search} />
+              )}
+              {view === 'logs' && (
+                <Logs onUnauthorized={handleLogout} search={search} />
+              )}
               {view === 'settings' && <Settings onUnauthorized={handleLogout} />}
               {COMING_SOON[view] && <ComingSoon title={COMING_SOON[view]} />}
             </main>
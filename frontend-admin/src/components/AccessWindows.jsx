@@ -0,0 +1,78 @@
+import { X } from 'lucide-react'
+import { Input } from './ui.jsx'
+
+const DAYS = ['Sun', 'Mon', 'Tue', 'Wed', 'Thu', 'Fri', 'Sat']
+
+const NEW_WINDOW = { days: [1, 2, 3, 4, 5], start: '08:00', end: '17:00' }
+
+// Editor for a list of weekly access windows ({days, start, end}). No days
+// selected means every day; an end before the start runs past midnight.
+export default function AccessWindows({ value = [], onChange }) {
+  const update = (i, patch) =>
+    onChange(value.map((w, j) => (j === i ? { ...w, ...patch } : w)))
+
+  const toggleDay = (i, day) => {
+    const days = value[i].days || []
+    update(i, {
+      days: days.includes(day)
+        ? days.filter((d) => d !== day)
+        : [...days, day].sort(),
+    })
+  }
+
+  return (
+    <div className="space-y-2">
+      {value.length === 0 && <p className="text-xs text-subtle">Any time.</p>}
+      {value.map((w, i) => (
+        <div key={i} className="flex flex-wrap items-center gap-2">
+          <div className="flex gap-1">
+            {DAYS.map((name, day) => (
+              <button
+                key={name}
+                type="button"
+                onClick={() => toggleDay(i, day)}
+                className={`rounded-md border px-1.5 py-0.5 text-xs ${
+                  (w.days || []).includes(day)
+                    ? 'border-brand bg-brand-softer text-brand-strong'
+                    : 'border-line-medium text-subtle'
+                }`}
+              >
+                {name}
+              </button>
+            ))}
+          </div>
+          <Input
+            type="time"
+            value={w.start}
+            onChange={(e) => update(i, { start: e.target.value })}
+            className="w-auto"
+            required
+          />
+          <span className="text-xs text-subtle">to</span>
+          <Input
+            type="time"
+            value={w.end}
+            onChange={(e) => update(i, { end: e.target.value })}
+            className="w-auto"
+            required
+          />
+          <button
+            type="button"
+            onClick={() => onChange(value.filter((_, j) => j !== i))}
+            className="rounded p-0.5 text-subtle transition hover:text-danger"
+            aria-label="Remove window"
+          >
+            <X className="h-3 w-3" />
+          </button>
+        </div>
+      ))}
+      <button
+        type="button"
+        onClick={() => onChange([...value, NEW_WINDOW])}
+        className="text-xs text-brand hover:underline"
+      >
+        Add time window
+      </button>
+    </div>
+  )
+}
@@ -0,0 +1,160 @@
+import { useCallback, useEffect, useState } from 'react'
+import { Palette } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Field } from './ui.jsx'
+
+const EMPTY = {
+  site_name: '',
+  logo: '',
+  primary_color: '',
+  accent_color: '',
+  welcome_text: '',
+  support_text: '',
+  footer_text: '',
+  terms_url: '',
+}
+
+// Branding shown on the customer portal. Empty fields keep the active
+// theme's own text and colours.
+export default function Branding({ onUnauthorized }) {
+  const [form, setForm] = useState(EMPTY)
+  const [saving, setSaving] = useState(false)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.branding()
+      if (res.status === 401) return onUnauthorized()
+      setForm({ ...EMPTY, ...(await asJson(res, 'Failed to load branding')) })
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const set = (key) => (e) => setForm({ ...form, [key]: e.target.value })
+
+  const save = async (e) => {
+    e.preventDefault()
+    setError('')
+    setNotice('')
+    setSaving(true)
+    try {
+      const res = await api.saveBranding(form)
+      if (res.status === 401) return onUnauthorized()
+      setForm({ ...EMPTY, ...(await asJson(res, 'Failed to save branding')) })
+      setNotice('Branding saved.')
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  const upload = async (e) => {
+    const file = e.target.files[0]
+    e.target.value = ''
+    if (!file) return
+    setError('')
+    setNotice('')
+    try {
+      const res = await api.uploadLogo(file)
+      if (res.status === 401) return onUnauthorized()
+      const data = await asJson(res, 'Failed to upload logo')
+      setForm({ ...form, logo: data.logo })
+      setNotice('Logo uploaded.')
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={Palette}>Portal Branding</CardTitle>
+      <p className="mb-4 text-xs text-subtle">
+        Shown on every portal theme. Leave a field empty to keep the
+        theme&apos;s own text or colour.
+      </p>
+      <form onSubmit={save} className="grid grid-cols-1 gap-4 sm:grid-cols-2">
+        <Field label="Site Name">
+          <Input
+            value={form.site_name}
+            onChange={set('site_name')}
+            placeholder="e.g., Rose Cafe WiFi"
+          />
+        </Field>
+        <Field label="Primary Color">
+          <Input
+            value={form.primary_color}
+            onChange={set('primary_color')}
+            placeholder="#f7931a"
+          />
+        </Field>
+        <Field label="Accent Color">
+          <Input
+            value={form.accent_color}
+            onChange={set('accent_color')}
+            placeholder="#ffd600"
+          />
+        </Field>
+        <Field label="Welcome Text" className="sm:col-span-2">
+          <Input
+            value={form.welcome_text}
+            onChange={set('welcome_text')}
+            placeholder="Enter your voucher code to connect to the internet."
+          />
+        </Field>
+        <Field label="Support Text">
+          <Input
+            value={form.support_text}
+            onChange={set('support_text')}
+            placeholder="Need help? Call 01700-000000"
+          />
+        </Field>
+        <Field label="Footer Text">
+          <Input value={form.footer_text} onChange={set('footer_text')} />
+        </Field>
+        <Field label="Terms URL" className="sm:col-span-2">
+          <Input
+            type="url"
+            value={form.terms_url}
+            onChange={set('terms_url')}
+            placeholder="https://example.com/terms"
+          />
+        </Field>
+        <Field label="Logo (PNG, JPEG, GIF or WebP, max 512 KB)">
+          <div className="flex items-center gap-4">
+            {form.logo && (
+              <img src={form.logo} alt="" className="h-10 w-10 object-contain" />
+            )}
+            <Input
+              type="file"
+              accept="image/png,image/jpeg,image/gif,image/webp"
+              onChange={upload}
+            />
+          </div>
+          {form.logo && (
+            <button
+              type="button"
+              onClick={() => setForm({ ...form, logo: '' })}
+              className="text-xs text-subtle hover:text-danger"
+            >
+              Remove logo (on save)
+            </button>
+          )}
+        </Field>
+        <div className="flex items-end gap-4 sm:col-span-2">
+          <Button type="submit" disabled={saving}>
+            {saving ? 'Saving...' : 'Save Branding'}
+          </Button>
+          {error && <p className="text-sm text-danger">{error}</p>}
+          {notice && !error && <p className="text-sm text-body">{notice}</p>}
+        </div>
+      </form>
+    </Card>
+  )
+}
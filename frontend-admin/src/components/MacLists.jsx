@@ -0,0 +1,130 @@
+import { useCallback, useEffect, useState } from 'react'
+import { ShieldCheck, X } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Select, Field } from './ui.jsx'
+
+const LISTS = [
+  { value: 'trusted', label: 'Trusted (bypass portal)' },
+  { value: 'blocked', label: 'Blocked (no access)' },
+  { value: 'allowed', label: 'Allowed (allow-list mode only)' },
+]
+
+// Manage the MACs the backend keeps on NoDogSplash's trusted, blocked and
+// allowed lists. Changes apply live and are re-applied on restart.
+export default function MacLists({ onUnauthorized }) {
+  const [lists, setLists] = useState({ trusted: [], blocked: [], allowed: [] })
+  const [form, setForm] = useState({ list: 'trusted', mac: '', note: '' })
+  const [error, setError] = useState('')
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.macLists()
+      if (res.status === 401) return onUnauthorized()
+      setLists(await asJson(res, 'Failed to load MAC lists'))
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const add = async (e) => {
+    e.preventDefault()
+    setError('')
+    try {
+      const res = await api.addListedMac(form.list, form.mac.trim(), form.note)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to add MAC')
+      setForm((f) => ({ ...f, mac: '', note: '' }))
+      load()
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
+  const remove = async (list, mac) => {
+    setError('')
+    try {
+      const res = await api.removeListedMac(list, mac)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to remove MAC')
+      load()
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={ShieldCheck}>Device Access Lists</CardTitle>
+      <form
+        onSubmit={add}
+        className="grid grid-cols-1 gap-4 sm:grid-cols-2 lg:grid-cols-4"
+      >
+        <Field label="List">
+          <Select
+            value={form.list}
+            onChange={(e) => setForm({ ...form, list: e.target.value })}
+          >
+            {LISTS.map((l) => (
+              <option key={l.value} value={l.value}>
+                {l.label}
+              </option>
+            ))}
+          </Select>
+        </Field>
+        <Field label="MAC Address">
+          <Input
+            value={form.mac}
+            onChange={(e) => setForm({ ...form, mac: e.target.value })}
+            placeholder="aa:bb:cc:dd:ee:ff"
+            required
+          />
+        </Field>
+        <Field label="Note (optional)">
+          <Input
+            value={form.note}
+            onChange={(e) => setForm({ ...form, note: e.target.value })}
+            placeholder="e.g., Front desk PC"
+          />
+        </Field>
+        <div className="flex items-end">
+          <Button type="submit" className="w-full">
+            Add Device
+          </Button>
+        </div>
+      </form>
+      {error && <p className="mt-4 text-sm text-danger">{error}</p>}
+
+      <div className="mt-6 grid grid-cols-1 gap-4 lg:grid-cols-3">
+        {LISTS.map((l) => (
+          <div key={l.value}>
+            <h4 className="mb-2 text-[13px] font-medium text-heading">
+              {l.label}
+            </h4>
+            {(lists[l.value] || []).length === 0 && (
+              <p className="text-xs text-subtle">No devices.</p>
+            )}
+            <ul className="space-y-1 text-xs text-body">
+              {(lists[l.value] || []).map((e) => (
+                <li key={e.mac} className="flex items-center gap-2">
+                  <span className="text-heading">{e.mac}</span>
+                  {e.note && <span className="text-subtle">{e.note}</span>}
+                  <button
+                    onClick={() => remove(l.value, e.mac)}
+                    className="ml-auto rounded p-0.5 text-subtle transition hover:text-danger"
+                    aria-label={`Remove ${e.mac}`}
+                  >
+                    <X className="h-3 w-3" />
+                  </button>
+                </li>
+              ))}
+            </ul>
+          </div>
+        ))}
+      </div>
+    </Card>
+  )
+}
@@ -0,0 +1,85 @@
+import { useCallback, useEffect, useState } from 'react'
+import { CalendarClock } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Field } from './ui.jsx'
+import AccessWindows from './AccessWindows.jsx'
+
+// Site-wide opening hours. Outside them the portal shows the closed message,
+// nobody can log in and running sessions are ended.
+export default function Schedule({ onUnauthorized }) {
+  const [windows, setWindows] = useState([])
+  const [message, setMessage] = useState('')
+  const [closed, setClosed] = useState(false)
+  const [saving, setSaving] = useState(false)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+
+  const apply = (data) => {
+    setWindows(data.windows || [])
+    setMessage(data.closed_message || '')
+    setClosed(data.closed)
+  }
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.schedule()
+      if (res.status === 401) return onUnauthorized()
+      apply(await asJson(res, 'Failed to load opening hours'))
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const save = async (e) => {
+    e.preventDefault()
+    setError('')
+    setNotice('')
+    setSaving(true)
+    try {
+      const res = await api.saveSchedule({ windows, closed_message: message })
+      if (res.status === 401) return onUnauthorized()
+      apply(await asJson(res, 'Failed to save opening hours'))
+      setNotice('Opening hours saved.')
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={CalendarClock}>Opening Hours</CardTitle>
+      <p className="mb-4 text-xs text-subtle">
+        When the portal lets anyone in, in router time. Outside these hours
+        connected devices are logged out; voucher time keeps running.
+        {closed && (
+          <span className="ml-1 font-medium text-danger">
+            The portal is closed right now.
+          </span>
+        )}
+      </p>
+      <form onSubmit={save} className="space-y-6">
+        <AccessWindows value={windows} onChange={setWindows} />
+        <Field label="Closed Message (optional)">
+          <Input
+            value={message}
+            onChange={(e) => setMessage(e.target.value)}
+            placeholder="e.g., WiFi is available 8am to 5pm on weekdays."
+          />
+        </Field>
+        <div className="flex items-center gap-4">
+          <Button type="submit" disabled={saving}>
+            {saving ? 'Saving...' : 'Save Opening Hours'}
+          </Button>
+          {error && <p className="text-sm text-danger">{error}</p>}
+          {notice && !error && <p className="text-sm text-body">{notice}</p>}
+        </div>
+      </form>
+    </Card>
+  )
+}
@@ -0,0 +1,125 @@
+import { useState } from 'react'
+import { LayoutTemplate, Trash2 } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Field } from './ui.jsx'
+
+// Installed portal themes, with upload of theme packages (a zip with an
+// index.html template and its assets) and removal of uploaded themes.
+export default function Themes({ themes, onChange, onUnauthorized }) {
+  const [file, setFile] = useState(null)
+  const [name, setName] = useState('')
+  const [uploading, setUploading] = useState(false)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+
+  const upload = async (e) => {
+    e.preventDefault()
+    if (!file) return
+    setError('')
+    setNotice('')
+    setUploading(true)
+    try {
+      const res = await api.uploadTheme(file, name.trim())
+      if (res.status === 401) return onUnauthorized()
+      const data = await asJson(res, 'Failed to upload theme')
+      setNotice(`Theme "${data.name}" installed.`)
+      setFile(null)
+      setName('')
+      e.target.reset()
+      onChange()
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setUploading(false)
+    }
+  }
+
+  const remove = async (t) => {
+    if (!window.confirm(`Delete theme ${t.label}?`)) return
+    setError('')
+    setNotice('')
+    try {
+      const res = await api.deleteTheme(t.name)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to delete theme')
+      onChange()
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={LayoutTemplate}>Portal Themes</CardTitle>
+      <ul className="mb-6 space-y-2 text-sm text-body">
+        {themes.map((t) => (
+          <li key={t.name} className="flex items-center gap-3">
+            <span className="text-heading">{t.label}</span>
+            <span className="text-xs text-subtle">
+              {t.builtin
+                ? 'built-in'
+                : [t.author, t.version].filter(Boolean).join(' · ')}
+            </span>
+            {t.active && (
+              <span className="text-xs text-success-strong">active</span>
+            )}
+            <a
+              href={api.previewUrl(t.name)}
+              target="_blank"
+              rel="noreferrer"
+              className="ml-auto text-xs text-brand hover:underline"
+            >
+              Preview
+            </a>
+            {!t.builtin && (
+              <button
+                onClick={() => remove(t)}
+                disabled={t.active}
+                className="rounded p-0.5 text-subtle transition hover:text-danger disabled:opacity-40"
+                aria-label={`Delete ${t.label}`}
+                title={t.active ? 'Switch to another theme first' : 'Delete'}
+              >
+                <Trash2 className="h-4 w-4" />
+              </button>
+            )}
+          </li>
+        ))}
+      </ul>
+
+      <p className="mb-4 text-xs text-subtle">
+        Upload a zip (max 2 MB) with an <code>index.html</code> template, an
+        optional <code>theme.json</code> ({'{'}label, description, author,
+        version{'}'}) and its CSS, scripts, images or fonts. Reference assets
+        as <code>{'{{.Assets}}'}style.css</code>.
+      </p>
+      <form onSubmit={upload} className="grid grid-cols-1 gap-4 sm:grid-cols-3">
+        <Field label="Theme Package">
+          <Input
+            type="file"
+            accept=".zip,application/zip"
+            onChange={(e) => setFile(e.target.files[0] || null)}
+            required
+          />
+        </Field>
+        <Field label="Name (optional)">
+          <Input
+            value={name}
+            onChange={(e) => setName(e.target.value)}
+            placeholder="defaults to the file name"
+          />
+        </Field>
+        <div className="flex items-end">
+          <Button
+            type="submit"
+            disabled={!file || uploading}
+            className="w-full"
+          >
+            {uploading ? 'Uploading...' : 'Upload Theme'}
+          </Button>
+        </div>
+      </form>
+      {error && <p className="mt-4 text-sm text-danger">{error}</p>}
+      {notice && !error && <p className="mt-4 text-sm text-body">{notice}</p>}
+    </Card>
+  )
+}
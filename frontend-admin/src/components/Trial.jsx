@@ -0,0 +1,171 @@
+import { useCallback, useEffect, useState } from 'react'
+import { Gift } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Textarea, Field } from './ui.jsx'
+
+const EMPTY = {
+  enabled: false,
+  minutes: 15,
+  cooldown_minutes: 1440,
+  mac_daily_limit: 0,
+  daily_limit: 0,
+  terms_text: '',
+}
+
+// Click-through mode: devices accept the terms of use for a short free
+// session. Saving changed terms publishes them as a new version, which
+// every device has to accept again.
+export default function Trial({ onUnauthorized }) {
+  const [form, setForm] = useState(EMPTY)
+  const [terms, setTerms] = useState(null)
+  const [acceptances, setAcceptances] = useState([])
+  const [saving, setSaving] = useState(false)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+
+  const apply = (data) => {
+    const current = data.terms?.length ? data.terms[data.terms.length - 1] : null
+    setTerms(current)
+    setForm({
+      enabled: data.enabled,
+      minutes: data.minutes,
+      cooldown_minutes: data.cooldown_minutes,
+      mac_daily_limit: data.mac_daily_limit,
+      daily_limit: data.daily_limit,
+      terms_text: current?.text || '',
+    })
+  }
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.trial()
+      if (res.status === 401) return onUnauthorized()
+      apply(await asJson(res, 'Failed to load free trial settings'))
+      const acc = await api.termsAcceptances()
+      if (acc.status === 401) return onUnauthorized()
+      setAcceptances(await asJson(acc, 'Failed to load acceptances'))
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const set = (key) => (e) => setForm({ ...form, [key]: e.target.value })
+  const setNumber = (key) => (e) =>
+    setForm({ ...form, [key]: parseInt(e.target.value, 10) || 0 })
+
+  const save = async (e) => {
+    e.preventDefault()
+    setError('')
+    setNotice('')
+    setSaving(true)
+    try {
+      const res = await api.saveTrial(form)
+      if (res.status === 401) return onUnauthorized()
+      apply(await asJson(res, 'Failed to save free trial settings'))
+      setNotice('Free trial settings saved.')
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={Gift}>Free Trial &amp; Terms of Use</CardTitle>
+      <p className="mb-4 text-xs text-subtle">
+        Devices without a voucher can accept the terms for a short free
+        session. The cooldown starts when a trial ends; limits of 0 mean
+        unlimited.
+      </p>
+      <form onSubmit={save} className="space-y-6">
+        <label className="flex items-center gap-2 text-sm text-heading">
+          <input
+            type="checkbox"
+            checked={form.enabled}
+            onChange={(e) => setForm({ ...form, enabled: e.target.checked })}
+          />
+          Offer a free trial on the portal
+        </label>
+        <div className="grid grid-cols-1 gap-4 sm:grid-cols-2 lg:grid-cols-4">
+          <Field label="Trial Length (minutes)">
+            <Input
+              type="number"
+              min="1"
+              max="1440"
+              value={form.minutes}
+              onChange={setNumber('minutes')}
+            />
+          </Field>
+          <Field label="Cooldown per Device (minutes)">
+            <Input
+              type="number"
+              min="0"
+              value={form.cooldown_minutes}
+              onChange={setNumber('cooldown_minutes')}
+            />
+          </Field>
+          <Field label="Trials per Device per Day">
+            <Input
+              type="number"
+              min="0"
+              value={form.mac_daily_limit}
+              onChange={setNumber('mac_daily_limit')}
+            />
+          </Field>
+          <Field label="Trials per Day (all devices)">
+            <Input
+              type="number"
+              min="0"
+              value={form.daily_limit}
+              onChange={setNumber('daily_limit')}
+            />
+          </Field>
+        </div>
+        <Field
+          label={
+            terms
+              ? `Terms of Use (version ${terms.version}, published ${new Date(terms.published).toLocaleString()})`
+              : 'Terms of Use (not published yet)'
+          }
+        >
+          <Textarea
+            rows={6}
+            value={form.terms_text}
+            onChange={set('terms_text')}
+            placeholder="The rules customers agree to before a free trial."
+          />
+        </Field>
+        <div className="flex items-center gap-4">
+          <Button type="submit" disabled={saving}>
+            {saving ? 'Saving...' : 'Save Free Trial'}
+          </Button>
+          {error && <p className="text-sm text-danger">{error}</p>}
+          {notice && !error && <p className="text-sm text-body">{notice}</p>}
+        </div>
+      </form>
+
+      <h4 className="mt-6 mb-2 text-[13px] font-medium text-heading">
+        Recent Acceptances
+      </h4>
+      <ul className="space-y-1 text-xs text-body">
+        {acceptances.length === 0 && (
+          <li className="text-subtle">No one has accepted the terms yet.</li>
+        )}
+        {acceptances.slice(0, 20).map((a) => (
+          <li key={a.id} className="flex flex-wrap gap-2">
+            <span className="text-heading">{a.mac}</span>
+            <span className="text-subtle">v{a.terms_version}</span>
+            <span className="text-subtle">
+              {new Date(a.accepted_at).toLocaleString()}
+            </span>
+          </li>
+        ))}
+      </ul>
+    </Card>
+  )
+}
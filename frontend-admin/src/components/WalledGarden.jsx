@@ -0,0 +1,152 @@
+import { useCallback, useEffect, useState } from 'react'
+import { Globe, X } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle, Button, Input, Select, Field } from './ui.jsx'
+
+const EMPTY = { host: '', protocol: 'tcp', port: '', note: '' }
+
+// Edit the destinations clients can reach before logging in. The list is
+// edited locally and applied in one go, because applying restarts NoDogSplash.
+export default function WalledGarden({ onUnauthorized }) {
+  const [entries, setEntries] = useState([])
+  const [form, setForm] = useState(EMPTY)
+  const [dirty, setDirty] = useState(false)
+  const [saving, setSaving] = useState(false)
+  const [error, setError] = useState('')
+  const [notice, setNotice] = useState('')
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.walledGarden()
+      if (res.status === 401) return onUnauthorized()
+      setEntries((await asJson(res, 'Failed to load walled garden')) || [])
+      setDirty(false)
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const add = (e) => {
+    e.preventDefault()
+    const port = parseInt(form.port, 10) || 0
+    setEntries([...entries, { ...form, host: form.host.trim(), port }])
+    setForm(EMPTY)
+    setDirty(true)
+  }
+
+  const remove = (i) => {
+    setEntries(entries.filter((_, j) => j !== i))
+    setDirty(true)
+  }
+
+  const apply = async () => {
+    setError('')
+    setNotice('')
+    setSaving(true)
+    try {
+      const res = await api.saveWalledGarden(entries)
+      if (res.status === 401) return onUnauthorized()
+      const data = await asJson(res, 'Failed to apply walled garden')
+      setNotice(
+        data.applied
+          ? 'Applied. NoDogSplash was restarted.'
+          : 'Saved. It will apply once NoDogSplash is installed.',
+      )
+      load()
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={Globe}>Walled Garden</CardTitle>
+      <p className="mb-4 text-xs text-subtle">
+        Hosts reachable before login, e.g. a payment page. Hostnames are
+        resolved when applied. Applying restarts NoDogSplash.
+      </p>
+      <form
+        onSubmit={add}
+        className="grid grid-cols-1 gap-4 sm:grid-cols-2 lg:grid-cols-5"
+      >
+        <Field label="Host, IP or CIDR">
+          <Input
+            value={form.host}
+            onChange={(e) => setForm({ ...form, host: e.target.value })}
+            placeholder="pay.example.com"
+            required
+          />
+        </Field>
+        <Field label="Protocol">
+          <Select
+            value={form.protocol}
+            onChange={(e) => setForm({ ...form, protocol: e.target.value })}
+          >
+            <option value="tcp">TCP</option>
+            <option value="udp">UDP</option>
+            <option value="all">All</option>
+          </Select>
+        </Field>
+        <Field label="Port (optional)">
+          <Input
+            type="number"
+            min="0"
+            max="65535"
+            value={form.port}
+            onChange={(e) => setForm({ ...form, port: e.target.value })}
+            placeholder="443"
+          />
+        </Field>
+        <Field label="Note (optional)">
+          <Input
+            value={form.note}
+            onChange={(e) => setForm({ ...form, note: e.target.value })}
+            placeholder="e.g., bKash checkout"
+          />
+        </Field>
+        <div className="flex items-end">
+          <Button type="submit" className="w-full">
+            Add Host
+          </Button>
+        </div>
+      </form>
+
+      <ul className="mt-6 space-y-1 text-xs text-body">
+        {entries.length === 0 && (
+          <li className="text-subtle">No walled-garden hosts.</li>
+        )}
+        {entries.map((e, i) => (
+          <li key={`${e.host}-${i}`} className="flex items-center gap-2">
+            <span className="text-heading">{e.host}</span>
+            <span className="text-subtle">
+              {e.protocol}
+              {e.port ? `:${e.port}` : ''}
+            </span>
+            {e.note && <span className="text-subtle">{e.note}</span>}
+            <button
+              onClick={() => remove(i)}
+              className="ml-auto rounded p-0.5 text-subtle transition hover:text-danger"
+              aria-label={`Remove ${e.host}`}
+            >
+              <X className="h-3 w-3" />
+            </button>
+          </li>
+        ))}
+      </ul>
+
+      <div className="mt-4 flex items-center gap-4">
+        <Button onClick={apply} disabled={!dirty || saving}>
+          {saving ? 'Applying...' : 'Save & Apply'}
+        </Button>
+        {error && <p className="text-sm text-danger">{error}</p>}
+        {notice && !error && <p className="text-sm text-body">{notice}</p>}
+      </div>
+    </Card>
+  )
+}
@@ -39,6 +39,10 @@ export function Input({ className = '', ...props }) {
   return <input className={`${fieldClasses} ${className}`} {...props} />
 }
 
+export function Textarea({ className = '', ...props }) {
+  return <textarea className={`${fieldClasses} ${className}`} {...props} />
+}
+
 export function Select({ className = '', children, ...props }) {
   return (
     <select className={`${fieldClasses} ${className}`} {...props}>
@@ -67,6 +71,8 @@ const chipStyles = {
   active: 'bg-success-soft text-success-strong border-success/30',
   expired: 'bg-danger-soft text-danger-strong border-danger/30',
   unused: 'bg-brand-softer text-brand-strong border-brand/30',
+  revoked: 'bg-neutral-medium text-subtle border-line-medium',
+  used: 'bg-neutral-medium text-subtle border-line-medium',
 }
 
 export function StatusChip({ status }) {
@@ -33,6 +33,79 @@ export const api = {
     req('/admin/add', { method: 'POST', body: JSON.stringify(voucher) }),
   deleteVoucher: (id) =>
     req('/admin/delete', { method: 'POST', body: JSON.stringify({ id }) }),
+  updateVoucher: (changes) =>
+    req('/admin/update', { method: 'PATCH', body: JSON.stringify(changes) }),
+  audit: (voucherId) => req(`/admin/audit?voucher_id=${voucherId}`),
+  revokeVoucher: (id) =>
+    req('/admin/revoke', { method: 'POST', body: JSON.stringify({ id }) }),
+  disconnect: (mac) =>
+    req('/admin/disconnect', { method: 'POST', body: JSON.stringify({ mac }) }),
+  topUp: (mac, { minutes = 0, data_mb = 0, voucher = '' }) =>
+    req('/admin/topup', {
+      method: 'POST',
+      body: JSON.stringify({ mac, minutes, data_mb, voucher }),
+    }),
+  sessions: ({ voucherId = '', mac = '' } = {}) =>
+    req(
+      `/admin/sessions?voucher_id=${voucherId}&mac=${encodeURIComponent(mac)}`,
+    ),
+  clients: () => req('/admin/clients'),
+  block: (mac) =>
+    req('/admin/block', { method: 'POST', body: JSON.stringify({ mac }) }),
+  unblock: (mac) =>
+    req('/admin/unblock', { method: 'POST', body: JSON.stringify({ mac }) }),
+  macLists: () => req('/admin/macs'),
+  addListedMac: (list, mac, note = '') =>
+    req('/admin/macs/add', {
+      method: 'POST',
+      body: JSON.stringify({ list, mac, note }),
+    }),
+  removeListedMac: (list, mac) =>
+    req('/admin/macs/remove', {
+      method: 'POST',
+      body: JSON.stringify({ list, mac }),
+    }),
+  walledGarden: () => req('/admin/walled-garden'),
+  saveWalledGarden: (entries) =>
+    req('/admin/walled-garden', {
+      method: 'POST',
+      body: JSON.stringify(entries),
+    }),
+  branding: () => req('/admin/branding'),
+  saveBranding: (branding) =>
+    req('/admin/branding', {
+      method: 'POST',
+      body: JSON.stringify(branding),
+    }),
+  // Multipart upload, so the JSON content type from req() must not be set.
+  uploadLogo: (file) => {
+    const body = new FormData()
+    body.append('logo', file)
+    return fetch('/admin/branding/logo', { method: 'POST', body })
+  },
+  themes: () => req('/admin/themes'),
+  uploadTheme: (file, name = '') => {
+    const body = new FormData()
+    body.append('theme', file)
+    if (name) body.append('name', name)
+    return fetch('/admin/themes/upload', { method: 'POST', body })
+  },
+  deleteTheme: (name) =>
+    req('/admin/themes/delete', {
+      method: 'POST',
+      body: JSON.stringify({ name }),
+    }),
+  schedule: () => req('/admin/schedule'),
+  saveSchedule: (schedule) =>
+    req('/admin/schedule', { method: 'POST', body: JSON.stringify(schedule) }),
+  trial: () => req('/admin/trial'),
+  saveTrial: (trial) =>
+    req('/admin/trial', { method: 'POST', body: JSON.stringify(trial) }),
+  termsAcceptances: (mac = '') =>
+    req(`/admin/trial/acceptances?mac=${encodeURIComponent(mac)}`),
+  previewUrl: (theme) => `/admin/preview?theme=${encodeURIComponent(theme)}`,
+  unbindDevice: (id, mac) =>
+    req('/admin/unbind', { method: 'POST', body: JSON.stringify({ id, mac }) }),
   settings: () => req('/admin/settings'),
   updateSettings: (settings) =>
     req('/admin/update-settings', {
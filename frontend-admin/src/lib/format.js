@@ -5,10 +5,22 @@ export function formatDuration(minutes) {
   return `${(minutes / 1440).toFixed(1)} days`
 }
 
-// Determine a voucher's status: 'unused' | 'active' | 'expired'.
-export function voucherStatus(voucher) {
-  if (!voucher.is_used) return 'unused'
-  const start = new Date(voucher.start_time).getTime()
-  const expires = start + voucher.duration * 60000
-  return Date.now() > expires ? 'expired' : 'active'
+// Convert a number of seconds to a compact "1d 2h 3m" style string.
+export function formatRemaining(seconds) {
+  if (!seconds || seconds <= 0) return '—'
+  const d = Math.floor(seconds / 86400)
+  const h = Math.floor((seconds % 86400) / 3600)
+  const m = Math.floor((seconds % 3600) / 60)
+  return [d && `${d}d`, h && `${h}h`, `${m}m`].filter(Boolean).join(' ')
+}
+
+// Convert a byte count to a human-friendly string.
+export function formatBytes(bytes) {
+  if (!bytes) return '0 B'
+  const units = ['B', 'KB', 'MB', 'GB', 'TB']
+  const i = Math.min(
+    Math.floor(Math.log(bytes) / Math.log(1024)),
+    units.length - 1,
+  )
+  return `${(bytes / 1024 ** i).toFixed(i ? 1 : 0)} ${units[i]}`
 }
@@ -0,0 +1,182 @@
+import { useCallback, useEffect, useState } from 'react'
+import { Users, WifiOff, Ban, Clock, RefreshCw } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle } from '../components/ui.jsx'
+import { formatBytes, formatRemaining } from '../lib/format.js'
+
+const REFRESH_MS = 15000
+
+function Action({ icon: Icon, label, onClick, danger }) {
+  return (
+    <button
+      onClick={onClick}
+      className={`flex h-8 w-8 items-center justify-center rounded-lg border border-line-medium bg-neutral-medium text-body transition ${
+        danger
+          ? 'hover:border-danger hover:bg-danger-soft hover:text-brand-strong'
+          : 'hover:border-brand hover:text-brand-strong'
+      }`}
+      aria-label={label}
+      title={label}
+    >
+      <Icon className="h-4 w-4" />
+    </button>
+  )
+}
+
+// Live view of the clients NoDogSplash knows about, merged with the voucher
+// each one is using.
+export default function Clients({ onUnauthorized, search = '' }) {
+  const [clients, setClients] = useState([])
+  const [error, setError] = useState('')
+
+  const load = useCallback(async () => {
+    try {
+      const res = await api.clients()
+      if (res.status === 401) return onUnauthorized()
+      setClients(await asJson(res, 'Failed to load clients'))
+      setError('')
+    } catch (err) {
+      setError(err.message)
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    load()
+    const timer = setInterval(load, REFRESH_MS)
+    return () => clearInterval(timer)
+  }, [load])
+
+  const run = async (request, fallback) => {
+    try {
+      const res = await request()
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, fallback)
+      load()
+    } catch (err) {
+      window.alert(err.message)
+    }
+  }
+
+  const kick = (c) =>
+    window.confirm(`Disconnect ${c.hostname || c.mac}?`) &&
+    run(() => api.disconnect(c.mac), 'Failed to disconnect client')
+
+  const toggleBlock = (c) =>
+    c.state === 'Blocked'
+      ? run(() => api.unblock(c.mac), 'Failed to unblock client')
+      : window.confirm(`Block ${c.hostname || c.mac}?`) &&
+        run(() => api.block(c.mac), 'Failed to block client')
+
+  const extend = (c) => {
+    const minutes = parseInt(window.prompt(`Minutes to add for ${c.mac}:`), 10)
+    if (minutes > 0) {
+      run(() => api.topUp(c.mac, { minutes }), 'Failed to extend session')
+    }
+  }
+
+  const query = search.trim().toLowerCase()
+  const filtered = query
+    ? clients.filter((c) =>
+        [c.mac, c.ip, c.hostname, c.voucher_code].some((f) =>
+          (f || '').toLowerCase().includes(query),
+        ),
+      )
+    : clients
+
+  return (
+    <Card className="animate-fadeIn p-0 sm:p-0">
+      <div className="flex items-center justify-between p-5 sm:p-6">
+        <CardTitle icon={Users} className="mb-0">
+          Connected Clients
+        </CardTitle>
+        <button
+          onClick={load}
+          className="rounded-base p-2 text-body transition hover:text-brand"
+          aria-label="Refresh"
+        >
+          <RefreshCw className="h-4 w-4" />
+        </button>
+      </div>
+      {error && <p className="px-6 pb-4 text-sm text-danger">{error}</p>}
+      <div className="overflow-x-auto">
+        <table className="w-full min-w-[860px] text-left text-sm">
+          <thead>
+            <tr className="border-y border-line bg-neutral-soft text-body">
+              {[
+                'Device',
+                'IP',
+                'State',
+                'Voucher',
+                'Remaining',
+                'Data Used',
+                'Idle',
+                'Actions',
+              ].map((h) => (
+                <th key={h} className="px-6 py-3 font-medium">
+                  {h}
+                </th>
+              ))}
+            </tr>
+          </thead>
+          <tbody>
+            {filtered.length === 0 && (
+              <tr>
+                <td colSpan={8} className="px-6 py-8 text-center text-subtle">
+                  No clients connected.
+                </td>
+              </tr>
+            )}
+            {filtered.map((c) => (
+              <tr
+                key={c.mac}
+                className="border-b border-line transition hover:bg-neutral-soft"
+              >
+                <td className="px-6 py-4">
+                  <div className="font-semibold text-heading">
+                    {c.hostname || 'Unknown device'}
+                  </div>
+                  <div className="text-xs text-subtle">{c.mac}</div>
+                </td>
+                <td className="px-6 py-4">{c.ip}</td>
+                <td className="px-6 py-4">{c.state}</td>
+                <td className="px-6 py-4 text-brand-strong">
+                  {c.voucher_code || '—'}
+                </td>
+                <td className="px-6 py-4">
+                  {formatRemaining(c.remaining_seconds)}
+                </td>
+                <td className="px-6 py-4">{formatBytes(c.data_used)}</td>
+                <td className="px-6 py-4">
+                  {formatRemaining(c.idle_seconds)}
+                </td>
+                <td className="px-6 py-4">
+                  <div className="flex gap-2">
+                    {c.voucher_id > 0 && (
+                      <Action
+                        icon={Clock}
+                        label="Extend session"
+                        onClick={() => extend(c)}
+                      />
+                    )}
+                    <Action
+                      icon={WifiOff}
+                      label="Disconnect"
+                      onClick={() => kick(c)}
+                      danger
+                    />
+                    <Action
+                      icon={Ban}
+                      label={c.state === 'Blocked' ? 'Unblock' : 'Block'}
+                      onClick={() => toggleBlock(c)}
+                      danger
+                    />
+                  </div>
+                </td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </div>
+    </Card>
+  )
+}
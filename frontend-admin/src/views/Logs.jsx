@@ -0,0 +1,89 @@
+import { useEffect, useState } from 'react'
+import { FileText } from 'lucide-react'
+import { api, asJson } from '../lib/api.js'
+import { Card, CardTitle } from '../components/ui.jsx'
+import { formatBytes } from '../lib/format.js'
+
+// Session history recorded from NoDogSplash BinAuth events: who was online,
+// on which voucher, for how long and how much data they used.
+export default function Logs({ onUnauthorized, search = '' }) {
+  const [sessions, setSessions] = useState([])
+  const [error, setError] = useState('')
+
+  useEffect(() => {
+    ;(async () => {
+      try {
+        const res = await api.sessions()
+        if (res.status === 401) return onUnauthorized()
+        setSessions(await asJson(res, 'Failed to load sessions'))
+      } catch (err) {
+        setError(err.message)
+      }
+    })()
+  }, [onUnauthorized])
+
+  const query = search.trim().toLowerCase()
+  const filtered = query
+    ? sessions.filter(
+        (s) => s.mac.includes(query) || (s.ip || '').includes(query),
+      )
+    : sessions
+
+  const formatTime = (t) =>
+    t && !t.startsWith('0001') ? new Date(t).toLocaleString() : '—'
+
+  return (
+    <Card className="animate-fadeIn p-0 sm:p-0">
+      <div className="p-5 sm:p-6">
+        <CardTitle icon={FileText} className="mb-0">
+          Session History
+        </CardTitle>
+        {error && <p className="mt-2 text-sm text-danger">{error}</p>}
+      </div>
+      <div className="overflow-x-auto">
+        <table className="w-full min-w-[720px] text-left text-sm">
+          <thead>
+            <tr className="border-y border-line bg-neutral-soft text-body">
+              {['MAC', 'IP', 'Voucher', 'Start', 'End', 'Reason', 'Data'].map(
+                (h) => (
+                  <th key={h} className="px-6 py-3 font-medium">
+                    {h}
+                  </th>
+                ),
+              )}
+            </tr>
+          </thead>
+          <tbody>
+            {filtered.length === 0 && (
+              <tr>
+                <td colSpan={7} className="px-6 py-8 text-center text-subtle">
+                  No sessions recorded.
+                </td>
+              </tr>
+            )}
+            {filtered.map((s) => (
+              <tr
+                key={s.id}
+                className="border-b border-line transition hover:bg-neutral-soft"
+              >
+                <td className="px-6 py-4 text-heading">{s.mac}</td>
+                <td className="px-6 py-4">{s.ip || '—'}</td>
+                <td className="px-6 py-4">
+                  {s.voucher_id ? `#${s.voucher_id}` : '—'}
+                </td>
+                <td className="px-6 py-4">{formatTime(s.start)}</td>
+                <td className="px-6 py-4">
+                  {s.end ? formatTime(s.end) : 'Online'}
+                </td>
+                <td className="px-6 py-4">{s.end_reason || '—'}</td>
+                <td className="px-6 py-4">
+                  {formatBytes(s.bytes_down)} ↓ / {formatBytes(s.bytes_up)} ↑
+                </td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </div>
+    </Card>
+  )
+}
@@ -1,14 +1,19 @@
-import { useEffect, useState } from 'react'
+import { useCallback, useEffect, useState } from 'react'
 import { Sliders, KeyRound } from 'lucide-react'
 import { api, asJson } from '../lib/api.js'
 import { useCurrency } from '../lib/currency.js'
 import { Card, CardTitle, Button, Input, Select, Field } from '../components/ui.jsx'
+import MacLists from '../components/MacLists.jsx'
+import WalledGarden from '../components/WalledGarden.jsx'
+import Branding from '../components/Branding.jsx'
+import Themes from '../components/Themes.jsx'
+import Trial from '../components/Trial.jsx'
+import Schedule from '../components/Schedule.jsx'
 
-const THEMES = [
-  { value: 'default', label: 'RoseNet (Matrix Pink)' },
-  { value: 'modern', label: 'QuickConnect (Clean Modern)' },
-  { value: 'corporate', label: 'GlobalNet (ISP Corporate)' },
-  { value: 'music', label: 'AsifNET (Retro Music)' },
+const LANGUAGES = [
+  { value: 'en', label: 'English' },
+  { value: 'bn', label: 'বাংলা (Bengali)' },
+  { value: 'hi', label: 'हिन्दी (Hindi)' },
 ]
 
 function Message({ message }) {
@@ -26,11 +31,27 @@ export default function Settings({ onUnauthorized }) {
   const { currency, setCurrency } = useCurrency()
   const [symbol, setSymbol] = useState(currency)
   const [theme, setTheme] = useState('default')
+  const [themes, setThemes] = useState([])
+  const [language, setLanguage] = useState('en')
   const [generalMsg, setGeneralMsg] = useState(null)
 
   const [pw, setPw] = useState({ old: '', next: '', confirm: '' })
   const [pwMsg, setPwMsg] = useState(null)
 
+  const loadThemes = useCallback(async () => {
+    try {
+      const res = await api.themes()
+      if (res.status === 401) return onUnauthorized()
+      setThemes(await asJson(res, 'Failed to load themes'))
+    } catch {
+      /* keep the current list */
+    }
+  }, [onUnauthorized])
+
+  useEffect(() => {
+    loadThemes()
+  }, [loadThemes])
+
   useEffect(() => {
     ;(async () => {
       try {
@@ -39,6 +60,7 @@ export default function Settings({ onUnauthorized }) {
         const s = await asJson(res, 'Failed to load settings')
         if (s.currency_symbol) setSymbol(s.currency_symbol)
         if (s.active_theme) setTheme(s.active_theme)
+        if (s.default_language) setLanguage(s.default_language)
       } catch {
         /* keep defaults */
       }
@@ -52,10 +74,12 @@ export default function Settings({ onUnauthorized }) {
       const res = await api.updateSettings({
         currency_symbol: symbol.trim(),
         active_theme: theme,
+        default_language: language,
       })
       if (res.status === 401) return onUnauthorized()
       await asJson(res, 'Failed to update settings')
       setCurrency(symbol.trim())
+      loadThemes()
       setGeneralMsg({ ok: true, text: 'Settings saved successfully!' })
     } catch (err) {
       setGeneralMsg({ ok: false, text: err.message })
@@ -94,18 +118,52 @@ export default function Settings({ onUnauthorized }) {
           </Field>
           <Field label="Portal Theme">
             <Select value={theme} onChange={(e) => setTheme(e.target.value)}>
-              {THEMES.map((t) => (
-                <option key={t.value} value={t.value}>
+              {themes.map((t) => (
+                <option key={t.name} value={t.name}>
                   {t.label}
                 </option>
               ))}
             </Select>
+            <a
+              href={api.previewUrl(theme)}
+              target="_blank"
+              rel="noreferrer"
+              className="inline-block text-xs text-brand hover:underline"
+            >
+              Preview with current branding
+            </a>
+          </Field>
+          <Field label="Default Portal Language">
+            <Select
+              value={language}
+              onChange={(e) => setLanguage(e.target.value)}
+            >
+              {LANGUAGES.map((l) => (
+                <option key={l.value} value={l.value}>
+                  {l.label}
+                </option>
+              ))}
+            </Select>
+            <p className="text-xs text-subtle">
+              Used when the customer's browser prefers none of these.
+            </p>
           </Field>
           <Button type="submit">Save Settings</Button>
           <Message message={generalMsg} />
         </form>
       </Card>
 
+      <Themes
+        themes={themes}
+        onChange={loadThemes}
+        onUnauthorized={onUnauthorized}
+      />
+      <Branding onUnauthorized={onUnauthorized} />
+      <Trial onUnauthorized={onUnauthorized} />
+      <Schedule onUnauthorized={onUnauthorized} />
+      <MacLists onUnauthorized={onUnauthorized} />
+      <WalledGarden onUnauthorized={onUnauthorized} />
+
       <Card>
         <CardTitle icon={KeyRound}>Change Admin Password</CardTitle>
         <form onSubmit={changePassword} className="max-w-md space-y-6">
@@ -1,9 +1,10 @@
 import { useEffect, useState } from 'react'
-import { Trash2, Plus } from 'lucide-react'
+import { Trash2, Plus, X, Pencil, Ban, WifiOff, Clock } from 'lucide-react'
 import { api, asJson } from '../lib/api.js'
 import { useCurrency } from '../lib/currency.js'
 import { Card, CardTitle, Button, Input, Select, Field, StatusChip } from '../components/ui.jsx'
-import { formatDuration, voucherStatus } from '../lib/format.js'
+import { formatDuration } from '../lib/format.js'
+import AccessWindows from '../components/AccessWindows.jsx'
 
 const UNIT_TO_MINUTES = { minutes: 1, days: 24 * 60, months: 30 * 24 * 60 }
 
@@ -13,7 +14,168 @@ const EMPTY_FORM = {
   unit: 'days',
   price: '',
   code: '',
+  devices: '',
+  redemptions: '',
   reusable: false,
+  activateBy: '',
+  lifetimeDays: '',
+  endDate: '',
+  windows: [],
+}
+
+// A date input's YYYY-MM-DD as the end of that day in local time.
+const endOfDay = (date) => new Date(`${date}T23:59:59`).toISOString()
+
+const dateValue = (t) => (!t || t.startsWith('0001') ? '' : t.slice(0, 10))
+
+// Inline editor for the mutable fields of an existing voucher. The backend
+// validates the change and records it in the voucher's audit trail.
+function EditVoucher({ voucher, onSaved, onCancel, onUnauthorized }) {
+  const { currency } = useCurrency()
+  const [form, setForm] = useState({
+    name: voucher.name || '',
+    code: voucher.code,
+    duration: String(voucher.duration || 0),
+    price: String(voucher.price || 0),
+    expiration: dateValue(voucher.expiration),
+    activateBy: dateValue(voucher.activate_by),
+    lifetime: String(voucher.lifetime || 0),
+    windows: voucher.access_windows || [],
+  })
+  const [history, setHistory] = useState([])
+  const [error, setError] = useState('')
+  const [saving, setSaving] = useState(false)
+
+  useEffect(() => {
+    ;(async () => {
+      try {
+        const res = await api.audit(voucher.id)
+        if (res.status === 401) return onUnauthorized()
+        setHistory(await asJson(res, 'Failed to load history'))
+      } catch {
+        /* history is informational only */
+      }
+    })()
+  }, [voucher.id, onUnauthorized])
+
+  const set = (key) => (e) => setForm((f) => ({ ...f, [key]: e.target.value }))
+
+  const save = async (e) => {
+    e.preventDefault()
+    setError('')
+    setSaving(true)
+    try {
+      const res = await api.updateVoucher({
+        id: voucher.id,
+        name: form.name.trim(),
+        ...(!voucher.is_used && { code: form.code.trim() }),
+        duration: parseInt(form.duration, 10) || 0,
+        price: parseFloat(form.price) || 0,
+        expiration: form.expiration,
+        activate_by: form.activateBy,
+        lifetime: parseInt(form.lifetime, 10) || 0,
+        access_windows: form.windows,
+      })
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to update voucher')
+      onSaved()
+    } catch (err) {
+      setError(err.message)
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  return (
+    <Card>
+      <CardTitle icon={Pencil}>Edit Voucher #{voucher.id}</CardTitle>
+      <form onSubmit={save} className="space-y-4">
+        <div className="grid grid-cols-1 gap-4 sm:grid-cols-2 lg:grid-cols-3">
+          <Field label="Name">
+            <Input value={form.name} onChange={set('name')} />
+          </Field>
+          <Field label={voucher.is_used ? 'Code (locked after use)' : 'Code'}>
+            <Input
+              value={form.code}
+              onChange={set('code')}
+              disabled={voucher.is_used}
+            />
+          </Field>
+          <Field label="Duration (minutes)">
+            <Input
+              type="number"
+              min="0"
+              value={form.duration}
+              onChange={set('duration')}
+            />
+          </Field>
+          <Field label={`Price (${currency})`}>
+            <Input
+              type="number"
+              step="0.01"
+              min="0"
+              value={form.price}
+              onChange={set('price')}
+            />
+          </Field>
+          <Field label="Activate By (optional)">
+            <Input
+              type="date"
+              value={form.activateBy}
+              onChange={set('activateBy')}
+            />
+          </Field>
+          <Field label="Lifetime After First Use (minutes, 0 = none)">
+            <Input
+              type="number"
+              min="0"
+              value={form.lifetime}
+              onChange={set('lifetime')}
+            />
+          </Field>
+          <Field label="End Date (optional)">
+            <Input
+              type="date"
+              value={form.expiration}
+              onChange={set('expiration')}
+            />
+          </Field>
+        </div>
+        <Field label="Access Hours">
+          <AccessWindows
+            value={form.windows}
+            onChange={(windows) => setForm((f) => ({ ...f, windows }))}
+          />
+        </Field>
+        <div className="flex flex-wrap items-center gap-4 pt-1">
+          <Button type="submit" disabled={saving} className="w-auto px-6">
+            {saving ? 'Saving…' : 'Save Changes'}
+          </Button>
+          <button
+            type="button"
+            onClick={onCancel}
+            className="text-sm text-body hover:text-heading"
+          >
+            Cancel
+          </button>
+          {error && <span className="text-sm text-danger">{error}</span>}
+        </div>
+      </form>
+      {history.length > 0 && (
+        <div className="mt-6 border-t border-line pt-4 text-xs text-body">
+          <h4 className="mb-2 font-medium text-heading">Change History</h4>
+          <ul className="space-y-1">
+            {history.map((h, i) => (
+              <li key={i}>
+                {new Date(h.time).toLocaleString()} — {h.action}
+                {h.field && ` ${h.field}: ${h.old} → ${h.new}`}
+              </li>
+            ))}
+          </ul>
+        </div>
+      )}
+    </Card>
+  )
 }
 
 export default function Vouchers({ onUnauthorized, search = '' }) {
@@ -22,6 +184,7 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
   const [form, setForm] = useState(EMPTY_FORM)
   const [submitting, setSubmitting] = useState(false)
   const [error, setError] = useState('')
+  const [editing, setEditing] = useState(null)
 
   const load = async () => {
     try {
@@ -54,7 +217,17 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
       duration: duration * (UNIT_TO_MINUTES[form.unit] || 1),
       price: parseFloat(form.price) || 0,
       is_reusable: form.reusable,
+      max_devices: parseInt(form.devices, 10) || 0,
+      ...(form.reusable && {
+        max_redemptions: parseInt(form.redemptions, 10) || 0,
+      }),
       ...(form.code.trim() && { code: form.code.trim() }),
+      ...(form.activateBy && { activate_by: endOfDay(form.activateBy) }),
+      ...(form.lifetimeDays && {
+        lifetime: (parseInt(form.lifetimeDays, 10) || 0) * 24 * 60,
+      }),
+      ...(form.endDate && { expiration: endOfDay(form.endDate) }),
+      ...(form.windows.length > 0 && { access_windows: form.windows }),
     }
     try {
       const res = await api.addVoucher(payload)
@@ -81,13 +254,77 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
     }
   }
 
+  const revoke = async (id) => {
+    if (
+      !window.confirm(
+        'Revoke this voucher? All of its devices are disconnected immediately.',
+      )
+    )
+      return
+    try {
+      const res = await api.revokeVoucher(id)
+      if (res.status === 401) return onUnauthorized()
+      const data = await asJson(res, 'Failed to revoke voucher')
+      if (data.disconnect_failed?.length) {
+        window.alert(`Could not disconnect: ${data.disconnect_failed.join(', ')}`)
+      }
+      load()
+    } catch (err) {
+      window.alert(err.message)
+    }
+  }
+
+  const disconnect = async (mac) => {
+    if (!window.confirm(`Disconnect ${mac} now?`)) return
+    try {
+      const res = await api.disconnect(mac)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to disconnect device')
+    } catch (err) {
+      window.alert(err.message)
+    }
+  }
+
+  const topUp = async (mac) => {
+    const input = window.prompt(
+      `Minutes to add to ${mac}'s session, or a voucher code to apply:`,
+    )
+    const value = input?.trim()
+    if (!value) return
+    // Generated codes can be all digits, so prefer a matching voucher.
+    const extra = vouchers.some((v) => v.code === value)
+      ? { voucher: value }
+      : { minutes: parseInt(value, 10) || 0 }
+    try {
+      const res = await api.topUp(mac, extra)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to top up session')
+      load()
+    } catch (err) {
+      window.alert(err.message)
+    }
+  }
+
+  const unbind = async (id, mac) => {
+    if (!window.confirm(`Unbind ${mac} from this voucher?`)) return
+    try {
+      const res = await api.unbindDevice(id, mac)
+      if (res.status === 401) return onUnauthorized()
+      await asJson(res, 'Failed to unbind device')
+      load()
+    } catch (err) {
+      window.alert(err.message)
+    }
+  }
+
   const query = search.trim().toLowerCase()
   const filtered = query
     ? vouchers.filter(
         (v) =>
           (v.name || '').toLowerCase().includes(query) ||
           (v.code || '').toLowerCase().includes(query) ||
-          (v.user_mac || '').toLowerCase().includes(query),
+          (v.user_mac || '').toLowerCase().includes(query) ||
+          (v.devices || []).some((d) => d.mac.includes(query)),
       )
     : vouchers
 
@@ -137,7 +374,56 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
                 placeholder="Leave blank for random"
               />
             </Field>
+            <Field label="Max Devices">
+              <Input
+                type="number"
+                value={form.devices}
+                onChange={set('devices')}
+                placeholder="1 (unlimited if reusable)"
+                min="0"
+              />
+            </Field>
+            {form.reusable && (
+              <Field label="Max Redemptions">
+                <Input
+                  type="number"
+                  value={form.redemptions}
+                  onChange={set('redemptions')}
+                  placeholder="Unlimited"
+                  min="0"
+                />
+              </Field>
+            )}
+            <Field label="Activate By (optional)">
+              <Input
+                type="date"
+                value={form.activateBy}
+                onChange={set('activateBy')}
+              />
+            </Field>
+            <Field label="Valid For After First Use (days)">
+              <Input
+                type="number"
+                value={form.lifetimeDays}
+                onChange={set('lifetimeDays')}
+                placeholder="No limit"
+                min="0"
+              />
+            </Field>
+            <Field label="End Date (optional)">
+              <Input
+                type="date"
+                value={form.endDate}
+                onChange={set('endDate')}
+              />
+            </Field>
           </div>
+          <Field label="Access Hours (optional, e.g. business hours only)">
+            <AccessWindows
+              value={form.windows}
+              onChange={(windows) => setForm((f) => ({ ...f, windows }))}
+            />
+          </Field>
           <div className="flex flex-wrap items-center gap-6 pt-1">
             <Button type="submit" disabled={submitting} className="w-auto px-6">
               {submitting ? 'Adding…' : 'Add Voucher'}
@@ -149,13 +435,26 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
                 onChange={set('reusable')}
                 className="h-4 w-4 rounded border-line-medium bg-neutral-medium text-brand accent-brand"
               />
-              Reusable
+              Reusable (shared code, time per device)
             </label>
             {error && <span className="text-sm text-danger">{error}</span>}
           </div>
         </form>
       </Card>
 
+      {editing && (
+        <EditVoucher
+          key={editing.id}
+          voucher={editing}
+          onUnauthorized={onUnauthorized}
+          onCancel={() => setEditing(null)}
+          onSaved={() => {
+            setEditing(null)
+            load()
+          }}
+        />
+      )}
+
       <Card className="p-0 sm:p-0">
         <div className="p-5 sm:p-6">
           <CardTitle className="mb-0">Existing Vouchers</CardTitle>
@@ -196,12 +495,68 @@ export default function Vouchers({ onUnauthorized, search = '' }) {
                     {(v.price || 0).toFixed(2)}
                   </td>
                   <td className="px-6 py-4">
-                    <StatusChip status={voucherStatus(v)} />
+                    <StatusChip status={v.status} />
+                    {v.expires_at && (
+                      <div className="mt-1 text-xs text-subtle">
+                        {v.status === 'expired' ? 'Ended' : 'Until'}{' '}
+                        {new Date(v.expires_at).toLocaleString()}
+                      </div>
+                    )}
                   </td>
                   <td className="px-6 py-4 text-xs text-body">
-                    {v.is_used ? v.user_mac || 'N/A' : '—'}
+                    {!v.is_used && '—'}
+                    {v.is_used && !(v.devices || []).length && 'N/A'}
+                    {v.is_reusable && (v.redemptions || []).length > 0 && (
+                      <div className="mb-1 text-subtle">
+                        {v.redemptions.length}
+                        {v.max_redemptions ? ` / ${v.max_redemptions}` : ''}{' '}
+                        redemptions
+                      </div>
+                    )}
+                    {(v.devices || []).map((d) => (
+                      <div key={d.mac} className="flex items-center gap-1">
+                        <span>{d.mac}</span>
+                        <button
+                          onClick={() => topUp(d.mac)}
+                          className="rounded p-0.5 text-subtle transition hover:text-brand"
+                          aria-label={`Top up ${d.mac}`}
+                        >
+                          <Clock className="h-3 w-3" />
+                        </button>
+                        <button
+                          onClick={() => disconnect(d.mac)}
+                          className="rounded p-0.5 text-subtle transition hover:text-danger"
+                          aria-label={`Disconnect ${d.mac}`}
+                        >
+                          <WifiOff className="h-3 w-3" />
+                        </button>
+                        <button
+                          onClick={() => unbind(v.id, d.mac)}
+                          className="rounded p-0.5 text-subtle transition hover:text-danger"
+                          aria-label={`Unbind ${d.mac}`}
+                        >
+                          <X className="h-3 w-3" />
+                        </button>
+                      </div>
+                    ))}
                   </td>
-                  <td className="px-6 py-4">
+                  <td className="flex gap-2 px-6 py-4">
+                    <button
+                      onClick={() => setEditing(v)}
+                      className="flex h-8 w-8 items-center justify-center rounded-lg border border-line-medium bg-neutral-medium text-body transition hover:border-brand hover:text-brand-strong"
+                      aria-label="Edit voucher"
+                    >
+                      <Pencil className="h-4 w-4" />
+                    </button>
+                    {!v.revoked && (
+                      <button
+                        onClick={() => revoke(v.id)}
+                        className="flex h-8 w-8 items-center justify-center rounded-lg border border-line-medium bg-neutral-medium text-body transition hover:border-danger hover:bg-danger-soft hover:text-brand-strong"
+                        aria-label="Revoke voucher"
+                      >
+                        <Ban className="h-4 w-4" />
+                      </button>
+                    )}
                     <button
                       onClick={() => remove(v.id)}
                       className="flex h-8 w-8 items-center justify-center rounded-lg border border-line-medium bg-neutral-medium text-body transition hover:border-danger hover:bg-danger-soft hover:text-brand-strong"
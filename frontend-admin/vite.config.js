@@ -1,9 +1,9 @@
 import { defineConfig } from 'vite'
 import react from '@vitejs/plugin-react'
 
-// The Go backend serves static files from `frontend/` (dev) or `/www/voucher`
-// (router). We emit the built admin SPA into `../frontend/admin` so it is served
-// at `/admin/` and gets copied to the router by scripts/install.sh.
+// The Go backend embeds `frontend/` (see scripts/embed-frontend.sh). We emit
+// the built admin SPA into `../frontend/admin` so it is embedded with it and
+// served at `/admin/`.
 //
 // `base: './'` keeps all asset URLs relative, so they resolve correctly under
 // the `/admin/` sub-path without hardcoding it.
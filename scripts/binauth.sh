@@ -1,37 +1,81 @@
 #!/bin/sh
 
-# Nodogsplash BinAuth Script
-# This script is called by Nodogsplash to authenticate a client.
+# Nodogsplash / openNDS BinAuth Script
+# This script is called by Nodogsplash or openNDS to authenticate a client and
+# to report every later change in the client's session.
 #
-# Arguments from Nodogsplash:
+# Arguments from Nodogsplash for "auth_client":
 # $1: "auth_client"
 # $2: Client's MAC address
-# $3: Client's IP address
-# $4: Username (not used in our flow)
-# $5: Password (not used in our flow)
-
-# We only care about the 'auth_client' action
-if [ "$1" != "auth_client" ]; then
-  exit 1
-fi
+# $3: Username ("voucher" when the backend staged the login)
+# $4: Password (the one-time nonce the backend put in the auth URL)
+#
+# openNDS orders "auth_client" differently and has no username or password:
+# $1: "auth_client"
+# $2: Client's MAC address
+# $3: Redirect URL
+# $4: Client's user agent
+# $5: Client's IP address
+# $6: Client token
+# $7: Custom string from the auth URL (the nonce; base64-encoded by some
+#     openNDS versions)
+#
+# Arguments for the session events (client_auth, client_deauth, idle_deauth,
+# timeout_deauth, ndsctl_auth, ndsctl_deauth, shutdown_deauth, and openNDS's
+# downquota_deauth and upquota_deauth):
+# $1: Event name
+# $2: Client's MAC address
+# $3: Bytes incoming (downloaded by the client)
+# $4: Bytes outgoing (uploaded by the client)
+# $5: Session start time (Unix seconds)
+# $6: Session end time (Unix seconds)
 
+BACKEND="http://127.0.0.1:7891"
 CLIENT_MAC=$2
 
 if [ -z "$CLIENT_MAC" ]; then
   exit 1
 fi
 
-# Ask the Go backend for the duration associated with this MAC address.
-# The backend should have this "staged" from the user's submission on the web page.
+case "$1" in
+  auth_client)
+    ;;
+  client_auth|client_deauth|idle_deauth|timeout_deauth|ndsctl_auth|ndsctl_deauth|shutdown_deauth|downquota_deauth|upquota_deauth)
+    # Forward the event so the backend can record session start/stop, bytes
+    # used and the reason. The daemon ignores our exit code for these.
+    curl -s -f -o /dev/null "${BACKEND}/binauth-event?action=$1&mac=${CLIENT_MAC}&in=$3&out=$4&start=$5&end=$6"
+    exit 0
+    ;;
+  *)
+    exit 1
+    ;;
+esac
+
+# Ask the Go backend for the duration staged for this MAC under the nonce.
+# The backend staged it when the user's voucher was accepted and consumes it
+# here, so the same auth URL cannot be replayed. Without a nonce the backend
+# only lets back in a device whose voucher is still running.
 # Use -s for silent, -f for fail silently on server errors.
-DURATION_SECONDS=$(curl -s -f "http://127.0.0.1:7891/binauth-check?mac=${CLIENT_MAC}")
+if [ -f /etc/init.d/opennds ]; then
+  NONCE=$7
+  case "$NONCE" in
+    *[!0-9a-f]*) NONCE=$(ndsctl b64decode "$NONCE" 2>/dev/null) ;;
+  esac
+else
+  NONCE=$4
+fi
+GRANT=$(curl -s -f "${BACKEND}/binauth-check?mac=${CLIENT_MAC}&nonce=${NONCE}")
 
-# Check if curl succeeded and if we got a duration back
-if [ $? -eq 0 ] && [ -n "$DURATION_SECONDS" ]; then
-  # Success: Echo the session details for Nodogsplash
-  # Format: <duration_seconds> <upload_limit_kbps> <download_limit_kbps>
-  # We use 0 for unlimited speed.
-  echo "$DURATION_SECONDS 0 0"
+# The backend replies "<seconds> <upload_rate> <download_rate> <upload_quota> <download_quota>"
+# (rates in kbit/s, quotas in kB, 0 = unlimited).
+if [ $? -eq 0 ] && [ -n "$GRANT" ]; then
+  if [ -f /etc/init.d/opennds ]; then
+    # openNDS takes all five fields, enforcing the data quota itself.
+    echo "$GRANT"
+  else
+    # Nodogsplash format: <duration_seconds> <upload_limit_kbps> <download_limit_kbps>
+    echo "$GRANT" | cut -d' ' -f1-3
+  fi
   exit 0
 else
   # Failure: The backend didn't authorize this MAC.
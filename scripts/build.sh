@@ -8,6 +8,9 @@ export GOARCH=arm
 
 echo "Building Go backend for OpenWRT..."
 
+# Embed the portal, themes and admin panel into the binary
+sh "$(dirname "$0")/embed-frontend.sh" || exit
+
 # Change to the backend directory
 cd "$(dirname "$0")/../backend" || exit
 
@@ -17,7 +20,7 @@ if [ $? -eq 0 ]; then
     echo "Build successful! Compressing with UPX..."
     upx --best --lzma ../voucher_server
     echo "Compression complete. The binary is 'voucher_server'."
-    echo "Copy 'voucher_server', the 'frontend/' directory, and 'voucher.db' to your router."
+    echo "Copy 'voucher_server' and the 'scripts/' directory to your router."
 else
     echo "Build failed."
 fi
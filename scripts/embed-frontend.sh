@@ -0,0 +1,24 @@
+#!/bin/sh
+
+# Copies frontend/ (portal, themes and the compiled admin SPA) into
+# backend/web/, which is embedded into the voucher_server binary.
+# Run it before `go build`; build.sh and the release workflow do.
+set -e
+
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+
+if [ ! -f "$ROOT/frontend/admin/index.html" ]; then
+    if command -v npm >/dev/null 2>&1; then
+        echo "Building admin frontend..."
+        (cd "$ROOT/frontend-admin" && npm ci && npm run build)
+    else
+        echo "Warning: frontend/admin/ is missing and npm is not installed;"
+        echo "the binary will not include the admin panel."
+    fi
+fi
+
+# Keep web/.gitkeep so the embed pattern stays valid in a fresh checkout.
+mkdir -p "$ROOT/backend/web"
+find "$ROOT/backend/web" -mindepth 1 -maxdepth 1 ! -name .gitkeep -exec rm -rf {} +
+cp -r "$ROOT/frontend"/. "$ROOT/backend/web/"
+echo "Embedded frontend assets into backend/web/"
@@ -1,8 +1,9 @@
 #!/bin/sh
 
 # This script should be run on the OpenWRT router.
-# It assumes you have copied the 'voucher_server' binary and the 'frontend' directory
-# to the /tmp/ directory on the router.
+# It assumes you have copied the 'voucher_server' binary and the 'scripts' directory
+# to the /tmp/ directory on the router. The portal and admin files are embedded
+# in the binary.
 
 # Determine the directory where this script is located
 SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
@@ -39,7 +40,11 @@ mkdir -p /data # For the persistent database
 echo "Copying application files..."
 cp "$RELEASE_ROOT/voucher_server" /opt/voucher/
 chmod +x /opt/voucher/voucher_server
-cp -r "$RELEASE_ROOT/frontend"/* /www/voucher/
+# /www/voucher only holds overrides and uploaded themes now. Remove the
+# portal files older releases copied here so they do not shadow the newer
+# ones embedded in the binary; uploaded themes (themes/<name>/) are kept.
+rm -rf /www/voucher/admin /www/voucher/index.html /www/voucher/admin.html /www/voucher/music.mp3
+rm -f /www/voucher/themes/*.html
 
 # Copy the binauth script and make it executable
 cp "$SCRIPT_DIR/binauth.sh" /opt/voucher/
@@ -86,28 +91,78 @@ chmod +x /etc/init.d/voucher
 /etc/init.d/voucher enable
 /etc/init.d/voucher start
 
-# 5. Install and configure NoDogSplash
-echo "Configuring NoDogSplash..."
-
-# Install NoDogSplash automatically if it is not already present.
-if [ ! -f /etc/init.d/nodogsplash ]; then
-    echo "NoDogSplash not found. Installing it via opkg..."
+# 5. Install and configure the captive-portal daemon. Older OpenWrt releases
+# ship NoDogSplash, newer ones its successor openNDS; whichever is installed
+# is configured, and NoDogSplash is installed if neither is.
+if [ -f /etc/init.d/opennds ]; then
+    PORTAL=opennds
+elif [ -f /etc/init.d/nodogsplash ]; then
+    PORTAL=nodogsplash
+else
+    echo "No captive-portal daemon found. Installing NoDogSplash via opkg..."
     opkg update
-    if ! opkg install nodogsplash; then
-        echo "Error: failed to install NoDogSplash via opkg."
+    if opkg install nodogsplash; then
+        PORTAL=nodogsplash
+    elif opkg install opennds; then
+        PORTAL=opennds
+    else
+        echo "Error: failed to install NoDogSplash or openNDS via opkg."
         echo "Check your internet connection and that the opkg feeds are reachable, then re-run this script."
         exit 1
     fi
 fi
+echo "Configuring $PORTAL..."
 
-# Backup existing config
-if [ -f /etc/config/nodogsplash ]; then
-    cp /etc/config/nodogsplash /etc/config/nodogsplash.bak
-fi
+if [ "$PORTAL" = "opennds" ]; then
+    if [ -f /etc/config/opennds ]; then
+        cp /etc/config/opennds /etc/config/opennds.bak
+    fi
+
+    # openNDS has no splash.html; it sends clients straight to the backend's
+    # /fas endpoint. Secure level 2 (the default here) base64-encodes the
+    # query and only sends the client token hashed with the faskey; level 3
+    # also encrypts it with AES-256-CBC.
+    FAS_KEY="$(head -c 32 /dev/urandom | md5sum | cut -d' ' -f1)"
+    echo "Creating openNDS configuration file (FAS secure level ${FAS_SECURE_LEVEL:-2})..."
+    cat << EOF > /etc/config/opennds
+config opennds 'setup'
+  option enabled '1'
+  option fwhook_enabled '1'
+  option gatewayinterface 'br-lan'
+  option maxclients '250'
+  option binauth '/opt/voucher/binauth.sh'
+  option preauthidletimeout '3'
+  option authidletimeout '1440'
+  option checkinterval '60'
+  option fasport '7891'
+  option fasremoteip '${LAN_IP}'
+  option faspath '/fas'
+  option fas_secure_enabled '${FAS_SECURE_LEVEL:-2}'
+  option faskey '${FAS_KEY}'
+  list preauthenticated_users 'allow tcp port 7891'
+  list preauthenticated_users 'allow udp port 7891'
+  list preauthenticated_users 'allow tcp port 53'
+  list preauthenticated_users 'allow udp port 53'
+  list users_to_router 'allow tcp port 22'
+  list users_to_router 'allow tcp port 23'
+  list users_to_router 'allow tcp port 53'
+  list users_to_router 'allow udp port 53'
+  list users_to_router 'allow udp port 67'
+  list users_to_router 'allow tcp port 80'
+  list users_to_router 'allow tcp port 7891'
+  list trustedmac 'ac:e0:10:81:1c:11'
+  list trustedmac 'b8:c3:85:7f:68:44'
+  list trustedmac 'd0:9c:7a:d6:5a:b8'
+EOF
+else
+    # Backup existing config
+    if [ -f /etc/config/nodogsplash ]; then
+        cp /etc/config/nodogsplash /etc/config/nodogsplash.bak
+    fi
 
-# Overwrite the config file directly. Given the uci issues, this is the most reliable method.
-echo "Creating NoDogSplash configuration file..."
-cat << 'EOF' > /etc/config/nodogsplash
+    # Overwrite the config file directly. Given the uci issues, this is the most reliable method.
+    echo "Creating NoDogSplash configuration file..."
+    cat << 'EOF' > /etc/config/nodogsplash
 config nodogsplash
   option enabled '1'
   option fwhook_enabled '1'
@@ -140,30 +195,74 @@ config nodogsplash
   list trustedmac 'd0:9c:7a:d6:5a:b8'
 EOF
 
+    # Optionally let NoDogSplash forward clients to the backend as a FAS server
+    # directly (set FAS_SECURE_LEVEL=0 or 1). On level 1 the client token is only
+    # sent hashed with a shared faskey, which the backend reads from this config.
+    if [ -n "$FAS_SECURE_LEVEL" ]; then
+        echo "Enabling FAS (secure level $FAS_SECURE_LEVEL)..."
+        FAS_KEY="$(head -c 32 /dev/urandom | md5sum | cut -d' ' -f1)"
+        cat << EOF >> /etc/config/nodogsplash
+  option fasport '7891'
+  option fasremoteip '${LAN_IP}'
+  option faspath '/fas'
+  option fas_secure_enabled '${FAS_SECURE_LEVEL}'
+  option faskey '${FAS_KEY}'
+EOF
+    fi
 
-# 6. Create the custom splash page for redirection
-echo "Creating custom NoDogSplash splash page..."
-mkdir -p /etc/nodogsplash/htdocs/
-# Note: unquoted heredoc so ${LAN_IP} expands, while NoDogSplash's own
-# $clientip/$clientmac/$tok variables are escaped to stay literal in the output.
-cat << EOF > /etc/nodogsplash/htdocs/splash.html
+    # 6. Create the custom splash page for redirection
+    echo "Creating custom NoDogSplash splash page..."
+    mkdir -p /etc/nodogsplash/htdocs/
+    # The splash page hands the NDS token to the backend's /fas endpoint, which
+    # verifies it against ndsctl and looks up the client's MAC itself.
+    # Note: unquoted heredoc so ${LAN_IP} expands, while NoDogSplash's own
+    # $tok/$authaction/$redir variables are escaped to stay literal in the output.
+    cat << EOF > /etc/nodogsplash/htdocs/splash.html
 <!DOCTYPE html>
 <html>
 <head>
     <meta charset="utf-8" />
     <title>Connecting...</title>
-    <meta http-equiv="refresh" content="0; url=http://${LAN_IP}:7891/?ip=\$clientip&amp;mac=\$clientmac&amp;token=\$tok" />
+    <meta http-equiv="refresh" content="0; url=http://${LAN_IP}:7891/fas?tok=\$tok&amp;authaction=\$authaction&amp;redir=\$redir" />
 </head>
 <body>
     <p>Please wait, you are being redirected to the login page...</p>
 </body>
 </html>
 EOF
+fi
+
+# 7. Restart the daemon to apply changes
+echo "Restarting $PORTAL..."
+/etc/init.d/$PORTAL restart
 
-# 7. Restart NoDogSplash to apply changes
-echo "Restarting NoDogSplash..."
-/etc/init.d/nodogsplash restart
+# 8. Advertise the Captive Portal API (RFC 8908) to clients in DHCP option 114
+# (RFC 8910) so phones and laptops can show the portal and remaining time
+# natively. Clients ignore non-HTTPS URLs, so this only happens when CAPPORT_URL
+# points at an HTTPS proxy in front of the backend's /captive-portal/api. Any
+# option 114 left by an earlier install is removed either way.
+for opt in $(uci -q get dhcp.lan.dhcp_option); do
+    case "$opt" in
+        114,*) uci del_list dhcp.lan.dhcp_option="$opt" ;;
+    esac
+done
+case "$CAPPORT_URL" in
+    https://*)
+        echo "Advertising the Captive Portal API at $CAPPORT_URL..."
+        uci add_list dhcp.lan.dhcp_option="114,${CAPPORT_URL}"
+        ;;
+    "")
+        echo "Warning: not advertising the Captive Portal API (DHCP option 114)."
+        echo "  Clients only accept an HTTPS URL; rerun with CAPPORT_URL=https://<host>/captive-portal/api"
+        echo "  once an HTTPS proxy forwards to http://${LAN_IP}:7891/captive-portal/api."
+        ;;
+    *)
+        echo "Warning: CAPPORT_URL must be an https:// URL; not advertising $CAPPORT_URL."
+        ;;
+esac
+uci commit dhcp
+/etc/init.d/dnsmasq restart
 
 echo "Installation complete!"
-echo "Your voucher server should be running and integrated with NoDogSplash."
+echo "Your voucher server should be running and integrated with $PORTAL."
 echo "You can access the admin panel at http://${LAN_IP}:7891/admin/"